@@ -3,10 +3,15 @@
 package config
 
 import (
+	"errors"
 	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
+	"google.golang.org/grpc/codes"
 
 	"github.com/luids-io/common/util"
 	"github.com/luids-io/core/grpctls"
@@ -17,6 +22,34 @@ type ClientCfg struct {
 	RemoteURI string
 	TLS       grpctls.ClientCfg
 	Metrics   bool
+	// load balancing between several backends
+	RemoteURIs  []string
+	Balancer    string
+	HealthCheck bool
+	TokenFile   string
+	// transport settings, zero values use grpc defaults
+	KeepaliveTime    time.Duration
+	KeepaliveTimeout time.Duration
+	KeepalivePermit  bool
+	MaxRecvMsgSize   int
+	MaxSendMsgSize   int
+	DialTimeout      time.Duration
+	UserAgent        string
+	WindowSize       int32
+	ConnWindowSize   int32
+	// retry policy, enabled if RetryMax is greater than 1
+	RetryMax        int
+	RetryBackoff    time.Duration
+	RetryMaxBackoff time.Duration
+	RetryMultiplier float64
+	RetryCodes      []string
+	RetryMethods    map[string]string
+	// connection backoff, zero values use grpc defaults
+	ConnBackoff    time.Duration
+	ConnMaxBackoff time.Duration
+	// default timeout of calls without deadline
+	CallTimeout  time.Duration
+	CallTimeouts map[string]string
 }
 
 // SetPFlags setups posix flags for commandline configuration.
@@ -30,6 +63,10 @@ func (cfg *ClientCfg) SetPFlags(short bool, prefix string) {
 	} else {
 		pflag.StringVar(&cfg.RemoteURI, aprefix+"uri", cfg.RemoteURI, "URI to grpc service.")
 	}
+	pflag.StringSliceVar(&cfg.RemoteURIs, aprefix+"uris", cfg.RemoteURIs, "List of URIs to grpc service backends.")
+	pflag.StringVar(&cfg.Balancer, aprefix+"balancer", cfg.Balancer, "Load balancing policy (pick_first, round_robin).")
+	pflag.BoolVar(&cfg.HealthCheck, aprefix+"healthcheck", cfg.HealthCheck, "Remove backends not serving grpc health service.")
+	pflag.StringVar(&cfg.TokenFile, aprefix+"tokenfile", cfg.TokenFile, "Path to file with the token sent to grpc service.")
 	pflag.StringVar(&cfg.TLS.CertFile, aprefix+"clientcert", cfg.TLS.CertFile, "Path to grpc client cert file.")
 	pflag.StringVar(&cfg.TLS.KeyFile, aprefix+"clientkey", cfg.TLS.KeyFile, "Path to grpc client key file.")
 	pflag.StringVar(&cfg.TLS.ServerCert, aprefix+"servercert", cfg.TLS.ServerCert, "Path to grpc server cert file.")
@@ -37,6 +74,25 @@ func (cfg *ClientCfg) SetPFlags(short bool, prefix string) {
 	pflag.StringVar(&cfg.TLS.CACert, aprefix+"cacert", cfg.TLS.CACert, "Path to grpc CA cert file.")
 	pflag.BoolVar(&cfg.TLS.UseSystemCAs, aprefix+"systemca", cfg.TLS.UseSystemCAs, "Use system CA pool for grpc check.")
 	pflag.BoolVar(&cfg.Metrics, aprefix+"metrics", cfg.Metrics, "Enable metrics.")
+	pflag.DurationVar(&cfg.KeepaliveTime, aprefix+"keepalivetime", cfg.KeepaliveTime, "Ping server after this time without activity.")
+	pflag.DurationVar(&cfg.KeepaliveTimeout, aprefix+"keepalivetimeout", cfg.KeepaliveTimeout, "Close connection if ping isn't answered in this time.")
+	pflag.BoolVar(&cfg.KeepalivePermit, aprefix+"keepalivepermit", cfg.KeepalivePermit, "Ping server without active streams.")
+	pflag.IntVar(&cfg.MaxRecvMsgSize, aprefix+"maxrecvmsgsize", cfg.MaxRecvMsgSize, "Maximum size in bytes of received messages.")
+	pflag.IntVar(&cfg.MaxSendMsgSize, aprefix+"maxsendmsgsize", cfg.MaxSendMsgSize, "Maximum size in bytes of sent messages.")
+	pflag.DurationVar(&cfg.DialTimeout, aprefix+"dialtimeout", cfg.DialTimeout, "Wait for connection up to this time when dialing.")
+	pflag.StringVar(&cfg.UserAgent, aprefix+"useragent", cfg.UserAgent, "User agent of grpc client.")
+	pflag.Int32Var(&cfg.WindowSize, aprefix+"windowsize", cfg.WindowSize, "Initial window size of streams.")
+	pflag.Int32Var(&cfg.ConnWindowSize, aprefix+"connwindowsize", cfg.ConnWindowSize, "Initial window size of connections.")
+	pflag.IntVar(&cfg.RetryMax, aprefix+"retrymax", cfg.RetryMax, "Maximum attempts of calls, including the first one (up to 5).")
+	pflag.DurationVar(&cfg.RetryBackoff, aprefix+"retrybackoff", cfg.RetryBackoff, "Initial backoff between retries.")
+	pflag.DurationVar(&cfg.RetryMaxBackoff, aprefix+"retrymaxbackoff", cfg.RetryMaxBackoff, "Maximum backoff between retries.")
+	pflag.Float64Var(&cfg.RetryMultiplier, aprefix+"retrymultiplier", cfg.RetryMultiplier, "Backoff multiplier between retries.")
+	pflag.StringSliceVar(&cfg.RetryCodes, aprefix+"retrycodes", cfg.RetryCodes, "Retryable status codes (e.g. UNAVAILABLE).")
+	pflag.StringToStringVar(&cfg.RetryMethods, aprefix+"retrymethods", cfg.RetryMethods, "Maximum attempts by service or method (e.g. pkg.Service/Method=1).")
+	pflag.DurationVar(&cfg.ConnBackoff, aprefix+"connbackoff", cfg.ConnBackoff, "Initial backoff between connection attempts.")
+	pflag.DurationVar(&cfg.ConnMaxBackoff, aprefix+"connmaxbackoff", cfg.ConnMaxBackoff, "Maximum backoff between connection attempts.")
+	pflag.DurationVar(&cfg.CallTimeout, aprefix+"calltimeout", cfg.CallTimeout, "Default timeout of calls without deadline.")
+	pflag.StringToStringVar(&cfg.CallTimeouts, aprefix+"calltimeouts", cfg.CallTimeouts, "Default timeout by service or method (e.g. pkg.Service/Method=5s).")
 }
 
 // BindViper and bind to viper.
@@ -46,6 +102,10 @@ func (cfg *ClientCfg) BindViper(v *viper.Viper, prefix string) {
 		aprefix = prefix + "."
 	}
 	util.BindViper(v, aprefix+"uri")
+	util.BindViper(v, aprefix+"uris")
+	util.BindViper(v, aprefix+"balancer")
+	util.BindViper(v, aprefix+"healthcheck")
+	util.BindViper(v, aprefix+"tokenfile")
 	util.BindViper(v, aprefix+"clientcert")
 	util.BindViper(v, aprefix+"clientkey")
 	util.BindViper(v, aprefix+"servercert")
@@ -53,6 +113,25 @@ func (cfg *ClientCfg) BindViper(v *viper.Viper, prefix string) {
 	util.BindViper(v, aprefix+"cacert")
 	util.BindViper(v, aprefix+"systemca")
 	util.BindViper(v, aprefix+"metrics")
+	util.BindViper(v, aprefix+"keepalivetime")
+	util.BindViper(v, aprefix+"keepalivetimeout")
+	util.BindViper(v, aprefix+"keepalivepermit")
+	util.BindViper(v, aprefix+"maxrecvmsgsize")
+	util.BindViper(v, aprefix+"maxsendmsgsize")
+	util.BindViper(v, aprefix+"dialtimeout")
+	util.BindViper(v, aprefix+"useragent")
+	util.BindViper(v, aprefix+"windowsize")
+	util.BindViper(v, aprefix+"connwindowsize")
+	util.BindViper(v, aprefix+"retrymax")
+	util.BindViper(v, aprefix+"retrybackoff")
+	util.BindViper(v, aprefix+"retrymaxbackoff")
+	util.BindViper(v, aprefix+"retrymultiplier")
+	util.BindViper(v, aprefix+"retrycodes")
+	util.BindViper(v, aprefix+"retrymethods")
+	util.BindViper(v, aprefix+"connbackoff")
+	util.BindViper(v, aprefix+"connmaxbackoff")
+	util.BindViper(v, aprefix+"calltimeout")
+	util.BindViper(v, aprefix+"calltimeouts")
 }
 
 // FromViper fill values from viper.
@@ -62,6 +141,10 @@ func (cfg *ClientCfg) FromViper(v *viper.Viper, prefix string) {
 		aprefix = prefix + "."
 	}
 	cfg.RemoteURI = v.GetString(aprefix + "uri")
+	cfg.RemoteURIs = v.GetStringSlice(aprefix + "uris")
+	cfg.Balancer = v.GetString(aprefix + "balancer")
+	cfg.HealthCheck = v.GetBool(aprefix + "healthcheck")
+	cfg.TokenFile = v.GetString(aprefix + "tokenfile")
 	cfg.TLS.CertFile = v.GetString(aprefix + "clientcert")
 	cfg.TLS.KeyFile = v.GetString(aprefix + "clientkey")
 	cfg.TLS.ServerCert = v.GetString(aprefix + "servercert")
@@ -69,11 +152,30 @@ func (cfg *ClientCfg) FromViper(v *viper.Viper, prefix string) {
 	cfg.TLS.CACert = v.GetString(aprefix + "cacert")
 	cfg.TLS.UseSystemCAs = v.GetBool(aprefix + "systemca")
 	cfg.Metrics = v.GetBool(aprefix + "metrics")
+	cfg.KeepaliveTime = v.GetDuration(aprefix + "keepalivetime")
+	cfg.KeepaliveTimeout = v.GetDuration(aprefix + "keepalivetimeout")
+	cfg.KeepalivePermit = v.GetBool(aprefix + "keepalivepermit")
+	cfg.MaxRecvMsgSize = v.GetInt(aprefix + "maxrecvmsgsize")
+	cfg.MaxSendMsgSize = v.GetInt(aprefix + "maxsendmsgsize")
+	cfg.DialTimeout = v.GetDuration(aprefix + "dialtimeout")
+	cfg.UserAgent = v.GetString(aprefix + "useragent")
+	cfg.WindowSize = v.GetInt32(aprefix + "windowsize")
+	cfg.ConnWindowSize = v.GetInt32(aprefix + "connwindowsize")
+	cfg.RetryMax = v.GetInt(aprefix + "retrymax")
+	cfg.RetryBackoff = v.GetDuration(aprefix + "retrybackoff")
+	cfg.RetryMaxBackoff = v.GetDuration(aprefix + "retrymaxbackoff")
+	cfg.RetryMultiplier = v.GetFloat64(aprefix + "retrymultiplier")
+	cfg.RetryCodes = v.GetStringSlice(aprefix + "retrycodes")
+	cfg.RetryMethods = v.GetStringMapString(aprefix + "retrymethods")
+	cfg.ConnBackoff = v.GetDuration(aprefix + "connbackoff")
+	cfg.ConnMaxBackoff = v.GetDuration(aprefix + "connmaxbackoff")
+	cfg.CallTimeout = v.GetDuration(aprefix + "calltimeout")
+	cfg.CallTimeouts = v.GetStringMapString(aprefix + "calltimeouts")
 }
 
 // Empty returns true if configuration is empty.
 func (cfg ClientCfg) Empty() bool {
-	if cfg.RemoteURI != "" {
+	if cfg.RemoteURI != "" || len(cfg.RemoteURIs) > 0 {
 		return false
 	}
 	if cfg.TLS.UseTLS() {
@@ -82,19 +184,144 @@ func (cfg ClientCfg) Empty() bool {
 	return true
 }
 
+// retryMaxAttempts is the limit of attempts applied by grpc to retry policies.
+const retryMaxAttempts = 5
+
 // Validate checks that configuration is ok.
 func (cfg ClientCfg) Validate() error {
-	_, _, err := grpctls.ParseURI(cfg.RemoteURI)
+	if cfg.RemoteURI != "" && len(cfg.RemoteURIs) > 0 {
+		return errors.New("uri and uris are mutually exclusive")
+	}
+	local := true
+	for _, uri := range cfg.URIs() {
+		proto, _, err := grpctls.ParseURI(uri)
+		if err != nil {
+			return err
+		}
+		if proto != "unix" {
+			local = false
+		}
+	}
+	if !util.IsValid(cfg.Balancer, []string{"", "pick_first", "round_robin"}) {
+		return errors.New("invalid balancer value")
+	}
+	if cfg.HealthCheck && cfg.Balancer != "round_robin" {
+		return errors.New("healthcheck requires round_robin balancer")
+	}
+	if cfg.KeepaliveTime < 0 {
+		return errors.New("invalid keepalivetime value")
+	}
+	if cfg.KeepaliveTimeout < 0 {
+		return errors.New("invalid keepalivetimeout value")
+	}
+	if cfg.MaxRecvMsgSize < 0 {
+		return errors.New("invalid maxrecvmsgsize value")
+	}
+	if cfg.MaxSendMsgSize < 0 {
+		return errors.New("invalid maxsendmsgsize value")
+	}
+	if cfg.DialTimeout < 0 {
+		return errors.New("invalid dialtimeout value")
+	}
+	// grpc ignores window sizes lower than 64KB
+	if cfg.WindowSize != 0 && cfg.WindowSize < 65535 {
+		return errors.New("invalid windowsize value")
+	}
+	if cfg.ConnWindowSize != 0 && cfg.ConnWindowSize < 65535 {
+		return errors.New("invalid connwindowsize value")
+	}
+	err := cfg.validateRetry()
 	if err != nil {
 		return err
 	}
+	if cfg.CallTimeout < 0 {
+		return errors.New("invalid calltimeout value")
+	}
+	err = validateMethodDurations("calltimeouts", cfg.CallTimeouts)
+	if err != nil {
+		return err
+	}
+	if cfg.TokenFile != "" {
+		if !util.FileExists(cfg.TokenFile) {
+			return fmt.Errorf("token file '%s' doesn't exists", cfg.TokenFile)
+		}
+		// tokens can't be sent in cleartext over the network
+		if !cfg.TLS.UseTLS() && !local {
+			return errors.New("tokenfile requires tls or unix sockets")
+		}
+	}
 	if cfg.TLS.UseTLS() {
 		return cfg.TLS.Validate()
 	}
 	return nil
 }
 
+func (cfg ClientCfg) validateRetry() error {
+	if cfg.RetryMax < 0 || cfg.RetryMax > retryMaxAttempts {
+		return errors.New("invalid retrymax value")
+	}
+	if cfg.RetryBackoff < 0 {
+		return errors.New("invalid retrybackoff value")
+	}
+	if cfg.RetryMaxBackoff < 0 || (cfg.RetryMaxBackoff > 0 && cfg.RetryMaxBackoff < cfg.RetryBackoff) {
+		return errors.New("invalid retrymaxbackoff value")
+	}
+	if cfg.RetryMultiplier < 0 {
+		return errors.New("invalid retrymultiplier value")
+	}
+	for _, name := range cfg.RetryCodes {
+		var code codes.Code
+		if err := code.UnmarshalJSON([]byte(strconv.Quote(strings.ToUpper(name)))); err != nil {
+			return fmt.Errorf("invalid retry code '%s'", name)
+		}
+	}
+	for name, value := range cfg.RetryMethods {
+		if !validMethodName(name) {
+			return fmt.Errorf("invalid retrymethods name '%s'", name)
+		}
+		if n, err := strconv.Atoi(value); err != nil || n < 0 || n > retryMaxAttempts {
+			return fmt.Errorf("invalid retrymethods value for '%s'", name)
+		}
+	}
+	if cfg.ConnBackoff < 0 {
+		return errors.New("invalid connbackoff value")
+	}
+	if cfg.ConnMaxBackoff < 0 || (cfg.ConnMaxBackoff > 0 && cfg.ConnMaxBackoff < cfg.ConnBackoff) {
+		return errors.New("invalid connmaxbackoff value")
+	}
+	return nil
+}
+
+// URIs returns the uris of the backends.
+func (cfg ClientCfg) URIs() []string {
+	if len(cfg.RemoteURIs) > 0 {
+		return cfg.RemoteURIs
+	}
+	return []string{cfg.RemoteURI}
+}
+
 // Dump configuration.
 func (cfg ClientCfg) Dump() string {
 	return fmt.Sprintf("%+v", cfg)
 }
+
+// validMethodName returns true if name has format "service" or
+// "service/method".
+func validMethodName(name string) bool {
+	name = strings.TrimPrefix(name, "/")
+	return name != "" && strings.Count(name, "/") <= 1 && !strings.HasSuffix(name, "/")
+}
+
+// validateMethodDurations checks a map of durations by service or method.
+func validateMethodDurations(key string, m map[string]string) error {
+	for name, value := range m {
+		if !validMethodName(name) {
+			return fmt.Errorf("invalid %s name '%s'", key, name)
+		}
+		d, err := time.ParseDuration(value)
+		if err != nil || d < 0 {
+			return fmt.Errorf("invalid %s value for '%s'", key, name)
+		}
+	}
+	return nil
+}
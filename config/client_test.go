@@ -0,0 +1,24 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package config
+
+import "testing"
+
+func TestClientCfgValidateRetry(t *testing.T) {
+	var tests = []struct {
+		cfg   ClientCfg
+		valid bool
+	}{
+		{ClientCfg{RetryMax: 5}, true},
+		{ClientCfg{RetryMax: 6}, false},
+		{ClientCfg{RetryMethods: map[string]string{"pkg.Service": "5"}}, true},
+		{ClientCfg{RetryMethods: map[string]string{"pkg.Service": "6"}}, false},
+	}
+	for _, test := range tests {
+		test.cfg.RemoteURI = "tcp://127.0.0.1:5000"
+		err := test.cfg.Validate()
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("Validate() with %+v: err = %v", test.cfg, err)
+		}
+	}
+}
@@ -5,7 +5,10 @@ package config
 import (
 	"errors"
 	"fmt"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -15,18 +18,65 @@ import (
 
 // LoggerCfg stores logger configuration preferences.
 type LoggerCfg struct {
+	Backend      string
+	Level        string
+	Format       string
+	TimeFormat   string
+	UTC          bool
+	Caller       bool
+	FieldMap     map[string]string
+	DupsWindow   map[string]string
+	Fields       map[string]string
+	HostFields   bool
+	AsyncBuffer  int
+	AsyncPolicy  string
+	Sinks        []string
+	EventService string
+	EventLevel   string
+	EventCode    int
+	EventRate    int
+	EventTimeout time.Duration
+	Recent       map[string]string
+}
+
+// LoggerSinkCfg stores the configuration of a logger output.
+type LoggerSinkCfg struct {
+	Output string
 	Level  string
 	Format string
 }
 
+// loggerLevels stores valid level values.
+var loggerLevels = []string{"panic", "fatal", "error", "warn", "warning", "info", "debug", "trace"}
+
+// loggerFormats stores valid format values.
+var loggerFormats = []string{"", "json", "text", "log", "logfmt", "ecs", "cef"}
+
 // SetPFlags setups posix flags for commandline configuration.
 func (cfg *LoggerCfg) SetPFlags(short bool, prefix string) {
 	aprefix := ""
 	if prefix != "" {
 		aprefix = prefix + "."
 	}
+	pflag.StringVar(&cfg.Backend, aprefix+"backend", cfg.Backend, "Log backend (logrus, slog, zap). Only logrus supports dupswindow, eventservice and recent.")
 	pflag.StringVar(&cfg.Level, aprefix+"level", cfg.Level, "Log level.")
 	pflag.StringVar(&cfg.Format, aprefix+"format", cfg.Format, "Log format.")
+	pflag.StringVar(&cfg.TimeFormat, aprefix+"timeformat", cfg.TimeFormat, "Timestamp layout.")
+	pflag.BoolVar(&cfg.UTC, aprefix+"utc", cfg.UTC, "Use UTC timestamps.")
+	pflag.BoolVar(&cfg.Caller, aprefix+"caller", cfg.Caller, "Report caller function and file.")
+	pflag.StringToStringVar(&cfg.FieldMap, aprefix+"fieldmap", cfg.FieldMap, "Rename default fields (time, level, msg, func, file).")
+	pflag.StringToStringVar(&cfg.DupsWindow, aprefix+"dupswindow", cfg.DupsWindow, "Suppress duplicated messages within a window by level (e.g. error=5s).")
+	pflag.StringToStringVar(&cfg.Fields, aprefix+"fields", cfg.Fields, "Static fields added to every entry.")
+	pflag.BoolVar(&cfg.HostFields, aprefix+"hostfields", cfg.HostFields, "Add program, pid and hostname fields.")
+	pflag.IntVar(&cfg.AsyncBuffer, aprefix+"asyncbuffer", cfg.AsyncBuffer, "Lines buffered by asynchronous writer (0 disables).")
+	pflag.StringVar(&cfg.AsyncPolicy, aprefix+"asyncpolicy", cfg.AsyncPolicy, "Policy when async buffer is full (block, drop).")
+	pflag.StringArrayVar(&cfg.Sinks, aprefix+"sink", cfg.Sinks, "Log output with format 'output=stderr|stdout|file[,level=level][,format=format]'.")
+	pflag.StringVar(&cfg.EventService, aprefix+"eventservice", cfg.EventService, "API Service ID of the event notifier for log entries.")
+	pflag.StringVar(&cfg.EventLevel, aprefix+"eventlevel", cfg.EventLevel, "Minimum level of log entries notified as events.")
+	pflag.IntVar(&cfg.EventCode, aprefix+"eventcode", cfg.EventCode, "Event code for log entries.")
+	pflag.IntVar(&cfg.EventRate, aprefix+"eventrate", cfg.EventRate, "Maximum events per minute.")
+	pflag.DurationVar(&cfg.EventTimeout, aprefix+"eventtimeout", cfg.EventTimeout, "Timeout notifying an event (0 uses default).")
+	pflag.StringToStringVar(&cfg.Recent, aprefix+"recent", cfg.Recent, "Recent entries kept in memory by level (e.g. error=100).")
 }
 
 // BindViper setups posix flags for commandline configuration and bind to viper.
@@ -35,8 +85,25 @@ func (cfg *LoggerCfg) BindViper(v *viper.Viper, prefix string) {
 	if prefix != "" {
 		aprefix = prefix + "."
 	}
+	util.BindViper(v, aprefix+"backend")
 	util.BindViper(v, aprefix+"level")
-	util.BindViper(v, aprefix+"fomat")
+	util.BindViper(v, aprefix+"format")
+	util.BindViper(v, aprefix+"timeformat")
+	util.BindViper(v, aprefix+"utc")
+	util.BindViper(v, aprefix+"caller")
+	util.BindViper(v, aprefix+"fieldmap")
+	util.BindViper(v, aprefix+"dupswindow")
+	util.BindViper(v, aprefix+"fields")
+	util.BindViper(v, aprefix+"hostfields")
+	util.BindViper(v, aprefix+"asyncbuffer")
+	util.BindViper(v, aprefix+"asyncpolicy")
+	util.BindViper(v, aprefix+"sink")
+	util.BindViper(v, aprefix+"eventservice")
+	util.BindViper(v, aprefix+"eventlevel")
+	util.BindViper(v, aprefix+"eventcode")
+	util.BindViper(v, aprefix+"eventrate")
+	util.BindViper(v, aprefix+"eventtimeout")
+	util.BindViper(v, aprefix+"recent")
 }
 
 // FromViper fill values from viper.
@@ -45,42 +112,220 @@ func (cfg *LoggerCfg) FromViper(v *viper.Viper, prefix string) {
 	if prefix != "" {
 		aprefix = prefix + "."
 	}
+	cfg.Backend = v.GetString(aprefix + "backend")
 	cfg.Level = v.GetString(aprefix + "level")
 	cfg.Format = v.GetString(aprefix + "format")
+	cfg.TimeFormat = v.GetString(aprefix + "timeformat")
+	cfg.UTC = v.GetBool(aprefix + "utc")
+	cfg.Caller = v.GetBool(aprefix + "caller")
+	cfg.FieldMap = v.GetStringMapString(aprefix + "fieldmap")
+	cfg.DupsWindow = v.GetStringMapString(aprefix + "dupswindow")
+	cfg.Fields = v.GetStringMapString(aprefix + "fields")
+	cfg.HostFields = v.GetBool(aprefix + "hostfields")
+	cfg.AsyncBuffer = v.GetInt(aprefix + "asyncbuffer")
+	cfg.AsyncPolicy = v.GetString(aprefix + "asyncpolicy")
+	cfg.Sinks = v.GetStringSlice(aprefix + "sink")
+	cfg.EventService = v.GetString(aprefix + "eventservice")
+	cfg.EventLevel = v.GetString(aprefix + "eventlevel")
+	cfg.EventCode = v.GetInt(aprefix + "eventcode")
+	cfg.EventRate = v.GetInt(aprefix + "eventrate")
+	cfg.EventTimeout = v.GetDuration(aprefix + "eventtimeout")
+	cfg.Recent = v.GetStringMapString(aprefix + "recent")
 }
 
 // Empty returns true if configuration is empty
 func (cfg LoggerCfg) Empty() bool {
+	if cfg.Backend != "" {
+		return false
+	}
 	if cfg.Level != "" {
 		return false
 	}
 	if cfg.Format != "" {
 		return false
 	}
+	if cfg.TimeFormat != "" || cfg.UTC || cfg.Caller {
+		return false
+	}
+	if len(cfg.FieldMap) > 0 {
+		return false
+	}
+	if len(cfg.DupsWindow) > 0 {
+		return false
+	}
+	if len(cfg.Fields) > 0 || cfg.HostFields {
+		return false
+	}
+	if cfg.AsyncBuffer > 0 || cfg.AsyncPolicy != "" {
+		return false
+	}
+	if len(cfg.Sinks) > 0 {
+		return false
+	}
+	if cfg.EventService != "" {
+		return false
+	}
+	if len(cfg.Recent) > 0 {
+		return false
+	}
 	return true
 }
 
 // Validate checks that configuration is ok.
 func (cfg LoggerCfg) Validate() error {
-	switch strings.ToLower(cfg.Format) {
+	if !util.IsValid(strings.ToLower(cfg.Format), loggerFormats) {
+		return errors.New("invalid format value")
+	}
+	for key, value := range cfg.FieldMap {
+		if !util.IsValid(key, []string{"time", "level", "msg", "func", "file"}) {
+			return fmt.Errorf("invalid fieldmap key '%s'", key)
+		}
+		if value == "" {
+			return fmt.Errorf("empty fieldmap value for '%s'", key)
+		}
+	}
+	for key := range cfg.Fields {
+		if key == "" {
+			return errors.New("empty field name")
+		}
+	}
+	for level, window := range cfg.DupsWindow {
+		if !util.IsValid(strings.ToLower(level), loggerLevels) {
+			return fmt.Errorf("invalid dupswindow level '%s'", level)
+		}
+		d, err := time.ParseDuration(window)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid dupswindow value for '%s'", level)
+		}
+	}
+	if cfg.AsyncBuffer < 0 {
+		return errors.New("invalid asyncbuffer")
+	}
+	switch strings.ToLower(cfg.AsyncPolicy) {
 	case "": //ok
-	case "json": //ok
-	case "text": //ok
-	case "log": //ok
+	case "block", "drop":
+		if cfg.AsyncBuffer == 0 {
+			return errors.New("asyncpolicy requires asyncbuffer")
+		}
 	default:
-		return errors.New("invalid format value")
+		return errors.New("invalid asyncpolicy value")
 	}
-	switch strings.ToLower(cfg.Level) {
-	case "error":
-		return nil
-	case "warn", "warning":
-		return nil
-	case "info":
-		return nil
-	case "debug":
+	for level, size := range cfg.Recent {
+		if !util.IsValid(strings.ToLower(level), loggerLevels) {
+			return fmt.Errorf("invalid recent level '%s'", level)
+		}
+		n, err := strconv.Atoi(size)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("invalid recent value for '%s'", level)
+		}
+	}
+	if cfg.EventService != "" {
+		if cfg.EventLevel != "" && !util.IsValid(strings.ToLower(cfg.EventLevel), loggerLevels) {
+			return errors.New("invalid eventlevel value")
+		}
+		if cfg.EventCode <= 0 {
+			return errors.New("invalid eventcode")
+		}
+		if cfg.EventRate <= 0 {
+			return errors.New("invalid eventrate")
+		}
+		if cfg.EventTimeout < 0 {
+			return errors.New("invalid eventtimeout")
+		}
+	}
+	if !util.IsValid(strings.ToLower(cfg.Level), loggerLevels) {
+		return errors.New("invalid level value")
+	}
+	sinks, err := cfg.SinksCfg()
+	if err != nil {
+		return err
+	}
+	if err := cfg.validateFieldMap(sinks); err != nil {
+		return err
+	}
+	return cfg.validateBackend()
+}
+
+// SinksCfg returns the outputs of the logger. Sinks inherit level and format
+// from the logger configuration and, if no sinks are defined, stderr is used.
+func (cfg LoggerCfg) SinksCfg() ([]LoggerSinkCfg, error) {
+	if len(cfg.Sinks) == 0 {
+		return []LoggerSinkCfg{{Output: "stderr", Level: cfg.Level, Format: cfg.Format}}, nil
+	}
+	sinks := make([]LoggerSinkCfg, 0, len(cfg.Sinks))
+	for _, def := range cfg.Sinks {
+		sink := LoggerSinkCfg{Level: cfg.Level, Format: cfg.Format}
+		for _, item := range strings.Split(def, ",") {
+			kv := strings.SplitN(item, "=", 2)
+			if len(kv) != 2 {
+				return nil, fmt.Errorf("invalid sink '%s'", def)
+			}
+			switch strings.TrimSpace(kv[0]) {
+			case "output":
+				sink.Output = strings.TrimSpace(kv[1])
+			case "level":
+				sink.Level = strings.TrimSpace(kv[1])
+			case "format":
+				sink.Format = strings.TrimSpace(kv[1])
+			default:
+				return nil, fmt.Errorf("invalid sink '%s': unknown key '%s'", def, kv[0])
+			}
+		}
+		switch sink.Output {
+		case "":
+			return nil, fmt.Errorf("invalid sink '%s': output is required", def)
+		case "stderr", "stdout": //ok
+		default:
+			if !util.DirExists(filepath.Dir(sink.Output)) {
+				return nil, fmt.Errorf("invalid sink '%s': dir doesn't exists", def)
+			}
+		}
+		if !util.IsValid(strings.ToLower(sink.Level), loggerLevels) {
+			return nil, fmt.Errorf("invalid sink '%s': invalid level value", def)
+		}
+		if !util.IsValid(strings.ToLower(sink.Format), loggerFormats) {
+			return nil, fmt.Errorf("invalid sink '%s': invalid format value", def)
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// validateFieldMap checks that the formats of the sinks can map the fields.
+func (cfg LoggerCfg) validateFieldMap(sinks []LoggerSinkCfg) error {
+	for _, sink := range sinks {
+		if strings.ToLower(sink.Format) != "cef" {
+			continue
+		}
+		for _, key := range []string{"level", "msg"} {
+			if _, ok := cfg.FieldMap[key]; ok {
+				return fmt.Errorf("fieldmap key '%s' is not supported by cef format", key)
+			}
+		}
+	}
+	return nil
+}
+
+// validateBackend checks that backend supports the options.
+func (cfg LoggerCfg) validateBackend() error {
+	backend := strings.ToLower(cfg.Backend)
+	switch backend {
+	case "", "logrus":
 		return nil
+	case "slog", "zap": //ok
+	default:
+		return errors.New("invalid backend value")
+	}
+	if len(cfg.DupsWindow) > 0 {
+		return fmt.Errorf("dupswindow is not supported by %s backend", backend)
+	}
+	if cfg.EventService != "" {
+		return fmt.Errorf("eventservice is not supported by %s backend", backend)
+	}
+	if len(cfg.Recent) > 0 {
+		return fmt.Errorf("recent is not supported by %s backend", backend)
 	}
-	return errors.New("invalid level value")
+	return nil
 }
 
 // Dump configuration.
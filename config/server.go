@@ -6,6 +6,8 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"path"
+	"time"
 
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
@@ -16,10 +18,34 @@ import (
 
 // ServerCfg stores server preferences.
 type ServerCfg struct {
-	ListenURI string
-	Allowed   []string
-	TLS       grpctls.ServerCfg
-	Metrics   bool
+	ListenURI     string
+	Allowed       []string
+	TLS           grpctls.ServerCfg
+	Metrics       bool
+	TokenFile     string
+	AccessLog     bool
+	AccessInclude []string
+	AccessExclude []string
+	AccessSample  int
+	Health        bool
+	Reflection    bool
+	Channelz      bool
+	DebugAllowed  []string
+	// connection settings, zero values use grpc defaults
+	KeepaliveTime        time.Duration
+	KeepaliveTimeout     time.Duration
+	KeepaliveMinTime     time.Duration
+	KeepalivePermit      bool
+	MaxConnIdle          time.Duration
+	MaxConnAge           time.Duration
+	MaxConnAgeGrace      time.Duration
+	MaxRecvMsgSize       int
+	MaxSendMsgSize       int
+	MaxConcurrentStreams uint32
+	ConnTimeout          time.Duration
+	// maximum deadline of incoming calls
+	MaxDeadline  time.Duration
+	MaxDeadlines map[string]string
 }
 
 // SetPFlags setups posix flags for commandline configuration.
@@ -39,6 +65,28 @@ func (cfg *ServerCfg) SetPFlags(short bool, prefix string) {
 	pflag.StringVar(&cfg.TLS.CACert, aprefix+"cacert", cfg.TLS.CACert, "Path to CA cert file.")
 	pflag.BoolVar(&cfg.TLS.ClientAuth, aprefix+"clientauth", cfg.TLS.ClientAuth, "Require client auth.")
 	pflag.BoolVar(&cfg.Metrics, aprefix+"metrics", cfg.Metrics, "Enable metrics.")
+	pflag.StringVar(&cfg.TokenFile, aprefix+"tokenfile", cfg.TokenFile, "Path to file with allowed tokens (name:token by line).")
+	pflag.BoolVar(&cfg.AccessLog, aprefix+"accesslog", cfg.AccessLog, "Enable access log.")
+	pflag.StringSliceVar(&cfg.AccessInclude, aprefix+"accessinclude", cfg.AccessInclude, "Log only methods matching patterns (e.g. /pkg.Service/*).")
+	pflag.StringSliceVar(&cfg.AccessExclude, aprefix+"accessexclude", cfg.AccessExclude, "Don't log methods matching patterns.")
+	pflag.IntVar(&cfg.AccessSample, aprefix+"accesssample", cfg.AccessSample, "Log one of every n successful calls.")
+	pflag.BoolVar(&cfg.Health, aprefix+"health", cfg.Health, "Enable grpc health service.")
+	pflag.BoolVar(&cfg.Reflection, aprefix+"reflection", cfg.Reflection, "Enable grpc reflection service.")
+	pflag.BoolVar(&cfg.Channelz, aprefix+"channelz", cfg.Channelz, "Enable grpc channelz service.")
+	pflag.StringSliceVar(&cfg.DebugAllowed, aprefix+"debugallowed", cfg.DebugAllowed, "List of IPs or CIDRs allowed to reflection and channelz instead of allowed.")
+	pflag.DurationVar(&cfg.KeepaliveTime, aprefix+"keepalivetime", cfg.KeepaliveTime, "Ping clients after this time without activity.")
+	pflag.DurationVar(&cfg.KeepaliveTimeout, aprefix+"keepalivetimeout", cfg.KeepaliveTimeout, "Close connection if ping isn't answered in this time.")
+	pflag.DurationVar(&cfg.KeepaliveMinTime, aprefix+"keepalivemintime", cfg.KeepaliveMinTime, "Minimum time between client pings.")
+	pflag.BoolVar(&cfg.KeepalivePermit, aprefix+"keepalivepermit", cfg.KeepalivePermit, "Permit client pings without active streams.")
+	pflag.DurationVar(&cfg.MaxConnIdle, aprefix+"maxconnidle", cfg.MaxConnIdle, "Close connections idle for this time.")
+	pflag.DurationVar(&cfg.MaxConnAge, aprefix+"maxconnage", cfg.MaxConnAge, "Maximum age of connections.")
+	pflag.DurationVar(&cfg.MaxConnAgeGrace, aprefix+"maxconnagegrace", cfg.MaxConnAgeGrace, "Time to complete calls after maximum age.")
+	pflag.IntVar(&cfg.MaxRecvMsgSize, aprefix+"maxrecvmsgsize", cfg.MaxRecvMsgSize, "Maximum size in bytes of received messages.")
+	pflag.IntVar(&cfg.MaxSendMsgSize, aprefix+"maxsendmsgsize", cfg.MaxSendMsgSize, "Maximum size in bytes of sent messages.")
+	pflag.Uint32Var(&cfg.MaxConcurrentStreams, aprefix+"maxstreams", cfg.MaxConcurrentStreams, "Maximum concurrent streams by connection.")
+	pflag.DurationVar(&cfg.ConnTimeout, aprefix+"conntimeout", cfg.ConnTimeout, "Timeout for connection establishment.")
+	pflag.DurationVar(&cfg.MaxDeadline, aprefix+"maxdeadline", cfg.MaxDeadline, "Maximum deadline of incoming calls.")
+	pflag.StringToStringVar(&cfg.MaxDeadlines, aprefix+"maxdeadlines", cfg.MaxDeadlines, "Maximum deadline by service or method (e.g. pkg.Service/Method=1m).")
 }
 
 // BindViper setups posix flags for commandline configuration and bind to viper.
@@ -54,6 +102,28 @@ func (cfg *ServerCfg) BindViper(v *viper.Viper, prefix string) {
 	util.BindViper(v, aprefix+"cacert")
 	util.BindViper(v, aprefix+"clientauth")
 	util.BindViper(v, aprefix+"metrics")
+	util.BindViper(v, aprefix+"tokenfile")
+	util.BindViper(v, aprefix+"accesslog")
+	util.BindViper(v, aprefix+"accessinclude")
+	util.BindViper(v, aprefix+"accessexclude")
+	util.BindViper(v, aprefix+"accesssample")
+	util.BindViper(v, aprefix+"health")
+	util.BindViper(v, aprefix+"reflection")
+	util.BindViper(v, aprefix+"channelz")
+	util.BindViper(v, aprefix+"debugallowed")
+	util.BindViper(v, aprefix+"keepalivetime")
+	util.BindViper(v, aprefix+"keepalivetimeout")
+	util.BindViper(v, aprefix+"keepalivemintime")
+	util.BindViper(v, aprefix+"keepalivepermit")
+	util.BindViper(v, aprefix+"maxconnidle")
+	util.BindViper(v, aprefix+"maxconnage")
+	util.BindViper(v, aprefix+"maxconnagegrace")
+	util.BindViper(v, aprefix+"maxrecvmsgsize")
+	util.BindViper(v, aprefix+"maxsendmsgsize")
+	util.BindViper(v, aprefix+"maxstreams")
+	util.BindViper(v, aprefix+"conntimeout")
+	util.BindViper(v, aprefix+"maxdeadline")
+	util.BindViper(v, aprefix+"maxdeadlines")
 }
 
 // FromViper fill values from viper.
@@ -69,6 +139,28 @@ func (cfg *ServerCfg) FromViper(v *viper.Viper, prefix string) {
 	cfg.TLS.CACert = v.GetString(aprefix + "cacert")
 	cfg.TLS.ClientAuth = v.GetBool(aprefix + "clientauth")
 	cfg.Metrics = v.GetBool(aprefix + "metrics")
+	cfg.TokenFile = v.GetString(aprefix + "tokenfile")
+	cfg.AccessLog = v.GetBool(aprefix + "accesslog")
+	cfg.AccessInclude = v.GetStringSlice(aprefix + "accessinclude")
+	cfg.AccessExclude = v.GetStringSlice(aprefix + "accessexclude")
+	cfg.AccessSample = v.GetInt(aprefix + "accesssample")
+	cfg.Health = v.GetBool(aprefix + "health")
+	cfg.Reflection = v.GetBool(aprefix + "reflection")
+	cfg.Channelz = v.GetBool(aprefix + "channelz")
+	cfg.DebugAllowed = v.GetStringSlice(aprefix + "debugallowed")
+	cfg.KeepaliveTime = v.GetDuration(aprefix + "keepalivetime")
+	cfg.KeepaliveTimeout = v.GetDuration(aprefix + "keepalivetimeout")
+	cfg.KeepaliveMinTime = v.GetDuration(aprefix + "keepalivemintime")
+	cfg.KeepalivePermit = v.GetBool(aprefix + "keepalivepermit")
+	cfg.MaxConnIdle = v.GetDuration(aprefix + "maxconnidle")
+	cfg.MaxConnAge = v.GetDuration(aprefix + "maxconnage")
+	cfg.MaxConnAgeGrace = v.GetDuration(aprefix + "maxconnagegrace")
+	cfg.MaxRecvMsgSize = v.GetInt(aprefix + "maxrecvmsgsize")
+	cfg.MaxSendMsgSize = v.GetInt(aprefix + "maxsendmsgsize")
+	cfg.MaxConcurrentStreams = v.GetUint32(aprefix + "maxstreams")
+	cfg.ConnTimeout = v.GetDuration(aprefix + "conntimeout")
+	cfg.MaxDeadline = v.GetDuration(aprefix + "maxdeadline")
+	cfg.MaxDeadlines = v.GetStringMapString(aprefix + "maxdeadlines")
 }
 
 // Empty returns true if configuration is empty.
@@ -85,6 +177,15 @@ func (cfg ServerCfg) Empty() bool {
 	if cfg.Metrics {
 		return false
 	}
+	if cfg.TokenFile != "" {
+		return false
+	}
+	if cfg.AccessLog {
+		return false
+	}
+	if cfg.Health || cfg.Reflection || cfg.Channelz {
+		return false
+	}
 	return true
 }
 
@@ -93,19 +194,68 @@ func (cfg *ServerCfg) Validate() error {
 	if cfg.ListenURI == "" {
 		return errors.New("listen uri is required")
 	}
-	_, _, err := util.ParseListenURI(cfg.ListenURI)
+	proto, _, err := util.ParseListenURI(cfg.ListenURI)
 	if err != nil {
 		return err
 	}
-	for _, item := range cfg.Allowed {
-		_, _, err = net.ParseCIDR(item)
-		if err != nil {
-			ip := net.ParseIP(item)
-			if ip == nil {
-				return fmt.Errorf("value '%v' is not a valid ip or cidr", item)
+	for _, items := range [][]string{cfg.Allowed, cfg.DebugAllowed} {
+		for _, item := range items {
+			_, _, err = net.ParseCIDR(item)
+			if err != nil {
+				ip := net.ParseIP(item)
+				if ip == nil {
+					return fmt.Errorf("value '%v' is not a valid ip or cidr", item)
+				}
 			}
 		}
 	}
+	for _, patterns := range [][]string{cfg.AccessInclude, cfg.AccessExclude} {
+		for _, pattern := range patterns {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("invalid access log pattern '%s'", pattern)
+			}
+		}
+	}
+	if cfg.AccessSample < 0 {
+		return errors.New("invalid accesssample value")
+	}
+	durations := map[string]time.Duration{
+		"keepalivetime":    cfg.KeepaliveTime,
+		"keepalivetimeout": cfg.KeepaliveTimeout,
+		"keepalivemintime": cfg.KeepaliveMinTime,
+		"maxconnidle":      cfg.MaxConnIdle,
+		"maxconnage":       cfg.MaxConnAge,
+		"maxconnagegrace":  cfg.MaxConnAgeGrace,
+		"conntimeout":      cfg.ConnTimeout,
+		"maxdeadline":      cfg.MaxDeadline,
+	}
+	for name, value := range durations {
+		if value < 0 {
+			return fmt.Errorf("invalid %s value", name)
+		}
+	}
+	if cfg.MaxRecvMsgSize < 0 {
+		return errors.New("invalid maxrecvmsgsize value")
+	}
+	if cfg.MaxSendMsgSize < 0 {
+		return errors.New("invalid maxsendmsgsize value")
+	}
+	if cfg.MaxConnAgeGrace > 0 && cfg.MaxConnAge == 0 {
+		return errors.New("maxconnagegrace requires maxconnage")
+	}
+	err = validateMethodDurations("maxdeadlines", cfg.MaxDeadlines)
+	if err != nil {
+		return err
+	}
+	if cfg.TokenFile != "" {
+		if !util.FileExists(cfg.TokenFile) {
+			return fmt.Errorf("token file '%s' doesn't exists", cfg.TokenFile)
+		}
+		// tokens can't be sent in cleartext over the network
+		if !cfg.TLS.UseTLS() && proto != "unix" {
+			return errors.New("tokenfile requires tls or an unix socket")
+		}
+	}
 	if cfg.TLS.UseTLS() {
 		return cfg.TLS.Validate()
 	}
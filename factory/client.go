@@ -4,9 +4,11 @@ package factory
 
 import (
 	"fmt"
+	"strings"
 
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
 
 	"github.com/luids-io/common/config"
 	"github.com/luids-io/core/grpctls"
@@ -18,15 +20,81 @@ func ClientConn(cfg *config.ClientCfg) (*grpc.ClientConn, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid client config: %v", err)
 	}
-	opts := make([]grpc.DialOption, 0)
+	uinterceptors := make([]grpc.UnaryClientInterceptor, 0)
+	sinterceptors := make([]grpc.StreamClientInterceptor, 0)
+	if timeouts := newMethodTimeouts(cfg.CallTimeout, cfg.CallTimeouts); !timeouts.empty() {
+		deadline := defaultDeadline{timeouts: timeouts}
+		uinterceptors = append(uinterceptors, deadline.unaryInterceptor)
+		sinterceptors = append(sinterceptors, deadline.streamInterceptor)
+	}
 	if cfg.Metrics {
-		opts = append(opts, grpc.WithUnaryInterceptor(grpc_prometheus.UnaryClientInterceptor))
-		opts = append(opts, grpc.WithStreamInterceptor(grpc_prometheus.StreamClientInterceptor))
+		uinterceptors = append(uinterceptors, grpc_prometheus.UnaryClientInterceptor)
+		sinterceptors = append(sinterceptors, grpc_prometheus.StreamClientInterceptor)
+	}
+	opts := make([]grpc.DialOption, 0)
+	if len(uinterceptors) > 0 {
+		opts = append(opts, grpc.WithChainUnaryInterceptor(uinterceptors...))
+		opts = append(opts, grpc.WithChainStreamInterceptor(sinterceptors...))
+	}
+	opts = append(opts, getGRPCDialOpts(cfg)...)
+	if cfg.TokenFile != "" {
+		creds, err := newTokenCreds(cfg.TokenFile, cfg.URIs())
+		if err != nil {
+			return nil, fmt.Errorf("loading token: %v", err)
+		}
+		opts = append(opts, grpc.WithPerRPCCredentials(creds))
 	}
 	//create dial
-	dial, err := grpctls.Dial(cfg.RemoteURI, cfg.TLS, opts...)
+	var dial *grpc.ClientConn
+	if len(cfg.RemoteURIs) > 0 {
+		dial, err = dialBalancer(cfg.RemoteURIs, cfg.TLS, opts...)
+	} else {
+		dial, err = grpctls.Dial(cfg.RemoteURI, cfg.TLS, opts...)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("cannot dial with %s: %v", cfg.RemoteURI, err)
+		return nil, fmt.Errorf("cannot dial with %s: %v", strings.Join(cfg.URIs(), ","), err)
 	}
 	return dial, err
 }
+
+// setup transport settings, zero values use grpc defaults
+func getGRPCDialOpts(cfg *config.ClientCfg) []grpc.DialOption {
+	opts := make([]grpc.DialOption, 0)
+	if cfg.KeepaliveTime > 0 || cfg.KeepaliveTimeout > 0 || cfg.KeepalivePermit {
+		opts = append(opts, grpc.WithKeepaliveParams(keepalive.ClientParameters{
+			Time:                cfg.KeepaliveTime,
+			Timeout:             cfg.KeepaliveTimeout,
+			PermitWithoutStream: cfg.KeepalivePermit,
+		}))
+	}
+	callopts := make([]grpc.CallOption, 0)
+	if cfg.MaxRecvMsgSize > 0 {
+		callopts = append(callopts, grpc.MaxCallRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		callopts = append(callopts, grpc.MaxCallSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	if len(callopts) > 0 {
+		opts = append(opts, grpc.WithDefaultCallOptions(callopts...))
+	}
+	if cfg.DialTimeout > 0 {
+		// grpctls.Dial doesn't accept a context
+		opts = append(opts, grpc.WithBlock(), grpc.WithTimeout(cfg.DialTimeout))
+	}
+	if cfg.UserAgent != "" {
+		opts = append(opts, grpc.WithUserAgent(cfg.UserAgent))
+	}
+	if cfg.WindowSize > 0 {
+		opts = append(opts, grpc.WithInitialWindowSize(cfg.WindowSize))
+	}
+	if cfg.ConnWindowSize > 0 {
+		opts = append(opts, grpc.WithInitialConnWindowSize(cfg.ConnWindowSize))
+	}
+	if sc := getServiceConfig(cfg); sc != "" {
+		opts = append(opts, grpc.WithDefaultServiceConfig(sc))
+	}
+	if params, ok := getConnectParams(cfg); ok {
+		opts = append(opts, grpc.WithConnectParams(params))
+	}
+	return opts
+}
@@ -0,0 +1,61 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/resolver"
+	"google.golang.org/grpc/resolver/manual"
+
+	"github.com/luids-io/core/grpctls"
+)
+
+// balancerScheme is the scheme of the resolver with the backends.
+const balancerScheme = "luids"
+
+// dialBalancer dials a connection to all the backends of a validated list of
+// uris. Backends are resolved by a manual resolver of the connection and tls
+// credentials are the same as grpctls.Dial.
+func dialBalancer(uris []string, tlsCfg grpctls.ClientCfg, opts ...grpc.DialOption) (*grpc.ClientConn, error) {
+	protos := make(map[string]string, len(uris))
+	addrs := make([]resolver.Address, 0, len(uris))
+	for _, uri := range uris {
+		proto, addr, _ := grpctls.ParseURI(uri)
+		protos[addr] = proto
+		// server name used by tls if it isn't set in the config
+		serverName := addr
+		if host, _, err := net.SplitHostPort(addr); err == nil {
+			serverName = host
+		}
+		addrs = append(addrs, resolver.Address{Addr: addr, ServerName: serverName})
+	}
+	r := manual.NewBuilderWithScheme(balancerScheme)
+	r.InitialState(resolver.State{Addresses: addrs})
+	dialer := func(ctx context.Context, addr string) (net.Conn, error) {
+		proto, ok := protos[addr]
+		if !ok {
+			proto = "tcp"
+		}
+		return (&net.Dialer{}).DialContext(ctx, proto, addr)
+	}
+	creds := insecure.NewCredentials()
+	if tlsCfg.UseTLS() {
+		var err error
+		creds, err = grpctls.ClientCreds(tlsCfg)
+		if err != nil {
+			return nil, err
+		}
+	}
+	opts = append(opts,
+		grpc.WithResolvers(r),
+		grpc.WithContextDialer(dialer),
+		grpc.WithTransportCredentials(creds))
+	target := fmt.Sprintf("%s:///%s", r.Scheme(), strings.Join(uris, ","))
+	return grpc.Dial(target, opts...)
+}
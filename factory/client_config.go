@@ -0,0 +1,157 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/backoff"
+	// registers the client health checking used by healthCheckConfig
+	_ "google.golang.org/grpc/health"
+
+	"github.com/luids-io/common/config"
+)
+
+// default values of the retry policy
+const (
+	retryBackoff    = 100 * time.Millisecond
+	retryMaxBackoff = time.Second
+	retryMultiplier = 2.0
+)
+
+var retryCodes = []string{"UNAVAILABLE"}
+
+// grpc service config, only the fields used are defined
+type serviceConfig struct {
+	LoadBalancingConfig []map[string]struct{} `json:"loadBalancingConfig,omitempty"`
+	HealthCheckConfig   *healthCheckConfig    `json:"healthCheckConfig,omitempty"`
+	MethodConfig        []methodConfig        `json:"methodConfig,omitempty"`
+}
+
+type healthCheckConfig struct {
+	ServiceName string `json:"serviceName"`
+}
+
+type methodConfig struct {
+	Name        []methodName `json:"name"`
+	RetryPolicy *retryPolicy `json:"retryPolicy,omitempty"`
+}
+
+type methodName struct {
+	Service string `json:"service,omitempty"`
+	Method  string `json:"method,omitempty"`
+}
+
+type retryPolicy struct {
+	MaxAttempts          int      `json:"maxAttempts"`
+	InitialBackoff       string   `json:"initialBackoff"`
+	MaxBackoff           string   `json:"maxBackoff"`
+	BackoffMultiplier    float64  `json:"backoffMultiplier"`
+	RetryableStatusCodes []string `json:"retryableStatusCodes"`
+}
+
+// getServiceConfig returns the service config with the balancing and retry
+// policies, it returns an empty string if none is configured.
+func getServiceConfig(cfg *config.ClientCfg) string {
+	if cfg.Balancer == "" && cfg.RetryMax <= 1 && len(cfg.RetryMethods) == 0 {
+		return ""
+	}
+	sc := serviceConfig{}
+	if cfg.Balancer != "" {
+		sc.LoadBalancingConfig = []map[string]struct{}{{cfg.Balancer: {}}}
+	}
+	if cfg.HealthCheck {
+		// empty service name checks the status of the server
+		sc.HealthCheckConfig = &healthCheckConfig{}
+	}
+	if cfg.RetryMax > 1 {
+		// empty name applies to all methods
+		sc.MethodConfig = append(sc.MethodConfig, methodConfig{
+			Name:        []methodName{{}},
+			RetryPolicy: getRetryPolicy(cfg, cfg.RetryMax),
+		})
+	}
+	names := make([]string, 0, len(cfg.RetryMethods))
+	for name := range cfg.RetryMethods {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		mc := methodConfig{Name: []methodName{parseMethodName(name)}}
+		// a method without retry policy disables retries
+		if attempts, _ := strconv.Atoi(cfg.RetryMethods[name]); attempts > 1 {
+			mc.RetryPolicy = getRetryPolicy(cfg, attempts)
+		}
+		sc.MethodConfig = append(sc.MethodConfig, mc)
+	}
+	data, _ := json.Marshal(sc)
+	return string(data)
+}
+
+func getRetryPolicy(cfg *config.ClientCfg, attempts int) *retryPolicy {
+	p := &retryPolicy{
+		MaxAttempts:          attempts,
+		InitialBackoff:       jsonDuration(retryBackoff),
+		MaxBackoff:           jsonDuration(retryMaxBackoff),
+		BackoffMultiplier:    retryMultiplier,
+		RetryableStatusCodes: retryCodes,
+	}
+	initial := retryBackoff
+	if cfg.RetryBackoff > 0 {
+		initial = cfg.RetryBackoff
+		p.InitialBackoff = jsonDuration(initial)
+	}
+	if cfg.RetryMaxBackoff > 0 {
+		p.MaxBackoff = jsonDuration(cfg.RetryMaxBackoff)
+	} else if initial > retryMaxBackoff {
+		p.MaxBackoff = p.InitialBackoff
+	}
+	if cfg.RetryMultiplier > 0 {
+		p.BackoffMultiplier = cfg.RetryMultiplier
+	}
+	if len(cfg.RetryCodes) > 0 {
+		p.RetryableStatusCodes = make([]string, 0, len(cfg.RetryCodes))
+		for _, code := range cfg.RetryCodes {
+			p.RetryableStatusCodes = append(p.RetryableStatusCodes, strings.ToUpper(code))
+		}
+	}
+	return p
+}
+
+// parseMethodName parses names with format "service" or "service/method".
+func parseMethodName(name string) methodName {
+	name = strings.TrimPrefix(name, "/")
+	if i := strings.Index(name, "/"); i >= 0 {
+		return methodName{Service: name[:i], Method: name[i+1:]}
+	}
+	return methodName{Service: name}
+}
+
+// jsonDuration returns the duration in the format of the service config.
+func jsonDuration(d time.Duration) string {
+	return strconv.FormatFloat(d.Seconds(), 'f', -1, 64) + "s"
+}
+
+// getConnectParams returns the connection backoff, zero values use grpc
+// defaults.
+func getConnectParams(cfg *config.ClientCfg) (grpc.ConnectParams, bool) {
+	if cfg.ConnBackoff == 0 && cfg.ConnMaxBackoff == 0 {
+		return grpc.ConnectParams{}, false
+	}
+	params := grpc.ConnectParams{Backoff: backoff.DefaultConfig}
+	if cfg.ConnBackoff > 0 {
+		params.Backoff.BaseDelay = cfg.ConnBackoff
+	}
+	if cfg.ConnMaxBackoff > 0 {
+		params.Backoff.MaxDelay = cfg.ConnMaxBackoff
+	}
+	if params.Backoff.MaxDelay < params.Backoff.BaseDelay {
+		params.Backoff.MaxDelay = params.Backoff.BaseDelay
+	}
+	return params, true
+}
@@ -0,0 +1,123 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+
+	"github.com/luids-io/core/yalogi"
+)
+
+var (
+	clientConnState = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "luids_grpc_client_connection_state",
+		Help: "Connectivity state of grpc client connections, 1 for the current state.",
+	}, []string{"name", "target", "state"})
+	clientMetricsOnce sync.Once
+)
+
+func registerClientMetrics() {
+	clientMetricsOnce.Do(func() {
+		prometheus.MustRegister(clientConnState)
+	})
+}
+
+var connStates = []connectivity.State{
+	connectivity.Idle,
+	connectivity.Connecting,
+	connectivity.Ready,
+	connectivity.TransientFailure,
+	connectivity.Shutdown,
+}
+
+// ClientMonitor watches the connectivity state of a grpc client connection.
+// It implements httphealth.Pingable interface.
+type ClientMonitor struct {
+	conn   *grpc.ClientConn
+	name   string
+	logger yalogi.Logger
+
+	mu    sync.Mutex
+	state connectivity.State
+}
+
+// MonitorClientConn watches the state of conn until it's closed. Transitions
+// are logged and exported as metrics labelled with name, which must be unique
+// for each monitored connection. Idle connections are reconnected, so lost
+// backends are detected without waiting for a call.
+func MonitorClientConn(conn *grpc.ClientConn, name string, logger yalogi.Logger) *ClientMonitor {
+	registerClientMetrics()
+	if logger == nil {
+		logger = yalogi.LogNull
+	}
+	m := &ClientMonitor{conn: conn, name: name, logger: logger, state: conn.GetState()}
+	m.export(m.state)
+	go m.run()
+	return m
+}
+
+// State returns the last state of the connection.
+func (m *ClientMonitor) State() connectivity.State {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.state
+}
+
+// Ping implements httphealth.Pingable interface, it returns an error while
+// the connection is in transient failure.
+func (m *ClientMonitor) Ping() error {
+	if m.State() == connectivity.TransientFailure {
+		return fmt.Errorf("grpc connection to '%s' in transient failure", m.conn.Target())
+	}
+	return nil
+}
+
+func (m *ClientMonitor) run() {
+	state := m.State()
+	for state != connectivity.Shutdown {
+		if state == connectivity.Idle {
+			m.conn.Connect()
+		}
+		if !m.conn.WaitForStateChange(context.Background(), state) {
+			return
+		}
+		next := m.conn.GetState()
+		m.log(state, next)
+		m.mu.Lock()
+		m.state = next
+		m.mu.Unlock()
+		m.export(next)
+		state = next
+	}
+	// connection closed
+	for _, s := range connStates {
+		clientConnState.DeleteLabelValues(m.name, m.conn.Target(), s.String())
+	}
+}
+
+func (m *ClientMonitor) log(from, to connectivity.State) {
+	switch to {
+	case connectivity.TransientFailure:
+		m.logger.Warnf("grpc connection '%s' to '%s': %v -> %v", m.name, m.conn.Target(), from, to)
+	case connectivity.Ready:
+		m.logger.Infof("grpc connection '%s' to '%s': %v -> %v", m.name, m.conn.Target(), from, to)
+	default:
+		m.logger.Debugf("grpc connection '%s' to '%s': %v -> %v", m.name, m.conn.Target(), from, to)
+	}
+}
+
+func (m *ClientMonitor) export(state connectivity.State) {
+	for _, s := range connStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		clientConnState.WithLabelValues(m.name, m.conn.Target(), s.String()).Set(value)
+	}
+}
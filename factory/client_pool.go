@@ -0,0 +1,132 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"errors"
+	"sync"
+
+	"google.golang.org/grpc"
+
+	"github.com/luids-io/common/config"
+)
+
+// SharedClientConn returns a connection from the client pool. Equivalent
+// configurations share the same connection, which is reference counted.
+// Each user must call ReleaseClientConn instead of closing the connection.
+// Connections are dialed without locking the pool, concurrent requests of a
+// connection being dialed wait for it.
+func SharedClientConn(cfg *config.ClientCfg) (*grpc.ClientConn, error) {
+	clientMutex.Lock()
+	// dump is deterministic: maps are printed sorted
+	key := cfg.Dump()
+	if item, ok := clientPool.get(key); ok {
+		item.refs++
+		clientMutex.Unlock()
+		<-item.ready
+		return item.conn, item.err
+	}
+	item := clientPool.set(key)
+	clientMutex.Unlock()
+
+	conn, err := ClientConn(cfg)
+
+	clientMutex.Lock()
+	defer clientMutex.Unlock()
+	defer close(item.ready)
+	if current, ok := clientPool.get(key); !ok || current != item {
+		// pool was reset while dialing
+		if err == nil {
+			conn.Close()
+			err = errors.New("client pool was reset")
+		}
+		item.err = err
+		return nil, err
+	}
+	if err != nil {
+		clientPool.remove(key)
+		item.err = err
+		return nil, err
+	}
+	item.conn = conn
+	return conn, nil
+}
+
+// ReleaseClientConn decrements the references of a connection returned by
+// SharedClientConn. When there are no more references, the connection is
+// closed and removed from the pool.
+func ReleaseClientConn(conn *grpc.ClientConn) error {
+	clientMutex.Lock()
+	key, item, ok := clientPool.find(conn)
+	if !ok {
+		clientMutex.Unlock()
+		return errors.New("client connection doesn't exists")
+	}
+	item.refs--
+	if item.refs > 0 {
+		clientMutex.Unlock()
+		return nil
+	}
+	clientPool.remove(key)
+	clientMutex.Unlock()
+
+	return conn.Close()
+}
+
+// ResetClientConns closes all the connections in the pool and empties it.
+// It's intended for tests.
+func ResetClientConns() {
+	clientMutex.Lock()
+	items := clientPool.items
+	clientPool.items = make(map[string]*clientItem)
+	clientMutex.Unlock()
+
+	for _, item := range items {
+		// connections being dialed are closed when dial finishes
+		if item.conn != nil {
+			item.conn.Close()
+		}
+	}
+}
+
+type clientItem struct {
+	conn  *grpc.ClientConn
+	err   error
+	refs  int
+	ready chan struct{} // closed when dial finishes
+}
+
+type clientConnPool struct {
+	items map[string]*clientItem
+}
+
+func (p *clientConnPool) get(key string) (*clientItem, bool) {
+	item, ok := p.items[key]
+	return item, ok
+}
+
+func (p *clientConnPool) find(conn *grpc.ClientConn) (string, *clientItem, bool) {
+	for key, item := range p.items {
+		if item.conn == conn {
+			return key, item, true
+		}
+	}
+	return "", nil, false
+}
+
+func (p *clientConnPool) set(key string) *clientItem {
+	item := &clientItem{refs: 1, ready: make(chan struct{})}
+	p.items[key] = item
+	return item
+}
+
+func (p *clientConnPool) remove(key string) {
+	delete(p.items, key)
+}
+
+var clientMutex sync.Mutex
+var clientPool clientConnPool
+
+func init() {
+	clientPool = clientConnPool{items: make(map[string]*clientItem)}
+}
@@ -0,0 +1,161 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+
+	"github.com/luids-io/common/config"
+	"github.com/luids-io/core/grpctls"
+)
+
+// flakyHealth fails the first calls with codes.Unavailable.
+type flakyHealth struct {
+	healthpb.UnimplementedHealthServer
+	failures int32
+	calls    int32
+}
+
+func (s *flakyHealth) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	if atomic.AddInt32(&s.calls, 1) <= s.failures {
+		return nil, status.Error(codes.Unavailable, "flaky")
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+func testFlakyServer(t *testing.T, failures int32, opts ...grpc.ServerOption) (*flakyHealth, string) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc := &flakyHealth{failures: failures}
+	srv := grpc.NewServer(opts...)
+	healthpb.RegisterHealthServer(srv, svc)
+	go srv.Serve(lis)
+	t.Cleanup(srv.Stop)
+	return svc, "tcp://" + lis.Addr().String()
+}
+
+func TestClientConnRetry(t *testing.T) {
+	var tests = []struct {
+		retryMax int
+		failures int32
+		want     codes.Code
+	}{
+		{0, 1, codes.Unavailable},
+		{3, 2, codes.OK},
+		{5, 4, codes.OK},
+		{3, 3, codes.Unavailable},
+	}
+	for _, test := range tests {
+		svc, uri := testFlakyServer(t, test.failures)
+		conn, err := ClientConn(&config.ClientCfg{
+			RemoteURI:    uri,
+			RetryMax:     test.retryMax,
+			RetryBackoff: time.Millisecond,
+		})
+		if err != nil {
+			t.Fatalf("ClientConn: %v", err)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+		cancel()
+		conn.Close()
+		if got := status.Code(err); got != test.want {
+			t.Errorf("retrymax=%v failures=%v: code = %v, want %v", test.retryMax, test.failures, got, test.want)
+		}
+		attempts := test.failures + 1
+		if test.want != codes.OK {
+			attempts = int32(test.retryMax)
+			if attempts < 1 {
+				attempts = 1
+			}
+		}
+		if got := atomic.LoadInt32(&svc.calls); got != attempts {
+			t.Errorf("retrymax=%v failures=%v: calls = %v, want %v", test.retryMax, test.failures, got, attempts)
+		}
+	}
+}
+
+// testCert returns the server credentials of a self-signed certificate for
+// 127.0.0.1 and the file with the certificate.
+func testCert(t *testing.T) (credentials.TransportCredentials, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(t.TempDir(), "server.crt")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	if err := ioutil.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return credentials.NewServerTLSFromCert(&tls.Certificate{
+		Certificate: [][]byte{der},
+		PrivateKey:  key,
+	}), certFile
+}
+
+func TestClientConnTLS(t *testing.T) {
+	creds, certFile := testCert(t)
+	_, uri1 := testFlakyServer(t, 0, grpc.Creds(creds))
+	_, uri2 := testFlakyServer(t, 0, grpc.Creds(creds))
+	var tests = []struct {
+		name string
+		cfg  config.ClientCfg
+	}{
+		{"single", config.ClientCfg{RemoteURI: uri1}},
+		{"balanced", config.ClientCfg{RemoteURIs: []string{uri1, uri2}, Balancer: "round_robin"}},
+	}
+	for _, test := range tests {
+		for _, tlsCfg := range []grpctls.ClientCfg{{CACert: certFile}, {ServerCert: certFile}} {
+			cfg := test.cfg
+			cfg.TLS = tlsCfg
+			conn, err := ClientConn(&cfg)
+			if err != nil {
+				t.Fatalf("%s: ClientConn: %v", test.name, err)
+			}
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			_, err = healthpb.NewHealthClient(conn).Check(ctx, &healthpb.HealthCheckRequest{})
+			cancel()
+			conn.Close()
+			if err != nil {
+				t.Errorf("%s %+v: Check: %v", test.name, tlsCfg, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,138 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+)
+
+// methodTimeouts returns a timeout for each method, overrides are defined by
+// service or by method.
+type methodTimeouts struct {
+	timeout   time.Duration
+	overrides map[string]time.Duration
+}
+
+// newMethodTimeouts returns timeouts from a validated config map.
+func newMethodTimeouts(timeout time.Duration, m map[string]string) methodTimeouts {
+	t := methodTimeouts{timeout: timeout, overrides: make(map[string]time.Duration, len(m))}
+	for name, value := range m {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			continue
+		}
+		t.overrides[strings.TrimPrefix(name, "/")] = d
+	}
+	return t
+}
+
+func (t methodTimeouts) empty() bool {
+	return t.timeout == 0 && len(t.overrides) == 0
+}
+
+// get returns the timeout of a full method name (/service/method), zero if
+// there is no timeout.
+func (t methodTimeouts) get(fullMethod string) time.Duration {
+	name := strings.TrimPrefix(fullMethod, "/")
+	if d, ok := t.overrides[name]; ok {
+		return d
+	}
+	if i := strings.Index(name, "/"); i >= 0 {
+		if d, ok := t.overrides[name[:i]]; ok {
+			return d
+		}
+	}
+	return t.timeout
+}
+
+// defaultDeadline sets a deadline to client calls without one.
+type defaultDeadline struct {
+	timeouts methodTimeouts
+}
+
+func (d defaultDeadline) context(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	if _, ok := ctx.Deadline(); ok {
+		return ctx, nil
+	}
+	timeout := d.timeouts.get(method)
+	if timeout <= 0 {
+		return ctx, nil
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (d defaultDeadline) unaryInterceptor(ctx context.Context, method string, req, reply interface{}, cc *grpc.ClientConn, invoker grpc.UnaryInvoker, opts ...grpc.CallOption) error {
+	ctx, cancel := d.context(ctx, method)
+	if cancel != nil {
+		defer cancel()
+	}
+	return invoker(ctx, method, req, reply, cc, opts...)
+}
+
+func (d defaultDeadline) streamInterceptor(ctx context.Context, desc *grpc.StreamDesc, cc *grpc.ClientConn, method string, streamer grpc.Streamer, opts ...grpc.CallOption) (grpc.ClientStream, error) {
+	ctx, cancel := d.context(ctx, method)
+	if cancel == nil {
+		return streamer(ctx, desc, cc, method, opts...)
+	}
+	stream, err := streamer(ctx, desc, cc, method, opts...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	return &deadlineStream{ClientStream: stream, cancel: cancel}, nil
+}
+
+// deadlineStream releases the context of the stream when it finishes.
+type deadlineStream struct {
+	grpc.ClientStream
+	cancel context.CancelFunc
+}
+
+// RecvMsg implements grpc.ClientStream interface.
+func (s *deadlineStream) RecvMsg(m interface{}) error {
+	err := s.ClientStream.RecvMsg(m)
+	if err != nil {
+		s.cancel()
+	}
+	return err
+}
+
+// maxDeadline caps the deadline of incoming calls.
+type maxDeadline struct {
+	timeouts methodTimeouts
+}
+
+func (d maxDeadline) context(ctx context.Context, method string) (context.Context, context.CancelFunc) {
+	timeout := d.timeouts.get(method)
+	if timeout <= 0 {
+		return ctx, nil
+	}
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) <= timeout {
+		return ctx, nil
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+func (d maxDeadline) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, cancel := d.context(ctx, info.FullMethod)
+	if cancel != nil {
+		defer cancel()
+	}
+	return handler(ctx, req)
+}
+
+func (d maxDeadline) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, cancel := d.context(ss.Context(), info.FullMethod)
+	if cancel == nil {
+		return handler(srv, ss)
+	}
+	defer cancel()
+	stream := grpc_middleware.WrapServerStream(ss)
+	stream.WrappedContext = ctx
+	return handler(srv, stream)
+}
@@ -4,9 +4,10 @@ package factory
 
 import (
 	"fmt"
-	"os"
-	"runtime"
+	"io"
+	"io/ioutil"
 	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
@@ -20,34 +21,108 @@ func Logger(cfg *config.LoggerCfg, debug bool) (yalogi.Logger, error) {
 	if err != nil {
 		return nil, fmt.Errorf("invalid logger config: %v", err)
 	}
-	level, _ := logrus.ParseLevel(cfg.Level)
-	logger := logrus.New()
-	if debug {
-		level = logrus.DebugLevel
-		logger.SetReportCaller(true)
-		logger.SetFormatter(&logrus.TextFormatter{
-			CallerPrettyfier: func(f *runtime.Frame) (string, string) {
-				gopath := os.Getenv("GOPATH")
-				if gopath == "" {
-					gopath = fmt.Sprintf("%s/go", os.Getenv("HOME"))
-				}
-				filename := strings.Replace(f.File, gopath, "/go", -1)
-				function := f.Function
-				fpath := strings.Split(f.Function, "/")
-				if len(fpath) > 0 {
-					function = fpath[len(fpath)-1]
+	sinks, err := logSinks(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("opening logger outputs: %v", err)
+	}
+	switch strings.ToLower(cfg.Backend) {
+	case "slog":
+		return newSlog(cfg, debug, sinks), nil
+	case "zap":
+		return newZap(cfg, debug, sinks), nil
+	}
+	return newLogrus(cfg, debug, sinks), nil
+}
+
+// FlushLogger writes the summaries of suppressed duplicates and waits until
+// pending entries of a logger created by Logger are written. If timeout is
+// zero it waits indefinitely.
+func FlushLogger(logger yalogi.Logger, timeout time.Duration) error {
+	var outs []io.Writer
+	if lg, ok := logrusLogger(logger); ok {
+		for _, formatter := range logrusFormatters(lg) {
+			if f, ok := formatter.(*dedupFormatter); ok {
+				if err := f.flush(); err != nil {
+					return err
 				}
-				return fmt.Sprintf("%s()", function), fmt.Sprintf("%s:%d", filename, f.Line)
-			}})
+			}
+		}
+		outs = logrusOutputs(lg)
+	}
+	switch l := logger.(type) {
+	case *slogLogger:
+		outs = l.outs
+	case *zapLogger:
+		outs = l.outs
+	}
+	for _, out := range outs {
+		if w, ok := out.(*asyncWriter); ok {
+			if err := w.flush(timeout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func newLogrus(cfg *config.LoggerCfg, debug bool, sinks []logSink) *logrus.Logger {
+	logger := logrus.New()
+	logger.SetReportCaller(cfg.Caller)
+	level := logrus.PanicLevel
+	for _, sink := range sinks {
+		if w, ok := sink.out.(*asyncWriter); ok {
+			syncOnExit(w)
+		}
+		if sinkLevel := logrusLevel(sink.level, debug); sinkLevel > level {
+			level = sinkLevel
+		}
 	}
 	logger.SetLevel(level)
-	switch strings.ToLower(cfg.Format) {
-	case "log":
-		logger.SetFormatter(&logrus.TextFormatter{DisableTimestamp: true})
-	case "text":
-		logger.SetFormatter(&logrus.TextFormatter{})
-	case "json":
-		logger.SetFormatter(&logrus.JSONFormatter{})
-	}
-	return logger, nil
+	// lazy fields must be resolved before other hooks and formatters
+	logger.AddHook(lazyHook{})
+	if len(cfg.Recent) > 0 {
+		logger.AddHook(newRecentHook(parseRecent(cfg.Recent)))
+	}
+	if len(sinks) == 1 {
+		logger.SetOutput(sinks[0].out)
+		logger.SetFormatter(logrusSinkFormatter(cfg, sinks[0].format, sinks[0].out))
+		return logger
+	}
+	// entries are written by sink hooks
+	logger.SetOutput(ioutil.Discard)
+	logger.SetFormatter(nopFormatter{})
+	for _, sink := range sinks {
+		logger.AddHook(&sinkHook{
+			levels:    logrus.AllLevels[:logrusLevel(sink.level, debug)+1],
+			formatter: logrusSinkFormatter(cfg, sink.format, sink.out),
+			out:       sink.out,
+		})
+	}
+	return logger
+}
+
+func logrusLevel(s string, debug bool) logrus.Level {
+	level, _ := logrus.ParseLevel(s)
+	if debug && level < logrus.DebugLevel {
+		return logrus.DebugLevel
+	}
+	return level
+}
+
+// logrusSinkFormatter returns the formatter chain for a sink writing to out.
+func logrusSinkFormatter(cfg *config.LoggerCfg, format string, out io.Writer) logrus.Formatter {
+	formatter := logrusFormatter(cfg, format)
+	if formatter == nil {
+		formatter = &logrus.TextFormatter{CallerPrettyfier: callerPrettyfier}
+	}
+	if cfg.UTC {
+		formatter = utcFormatter{formatter}
+	}
+	if fields := staticFields(cfg); len(fields) > 0 {
+		formatter = fieldsFormatter{Formatter: formatter, fields: fields}
+	}
+	if len(cfg.DupsWindow) > 0 {
+		formatter = newDedupFormatter(formatter, parseDupsWindow(cfg.DupsWindow), out)
+	}
+	return formatter
 }
@@ -0,0 +1,137 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/sirupsen/logrus"
+)
+
+// asyncFlushTimeout is the time waiting for pending lines on exit.
+const asyncFlushTimeout = 5 * time.Second
+
+var errFlushTimeout = errors.New("timeout flushing log")
+
+var (
+	logDroppedLines = prometheus.NewCounter(prometheus.CounterOpts{
+		Name: "luids_log_dropped_lines_total",
+		Help: "Total number of log lines dropped by the asynchronous writer.",
+	})
+	logMetricsOnce sync.Once
+)
+
+var (
+	exitWritersMu sync.Mutex
+	exitWriters   []*asyncWriter
+	exitOnce      sync.Once
+)
+
+func registerLogMetrics() {
+	logMetricsOnce.Do(func() {
+		prometheus.MustRegister(logDroppedLines)
+	})
+}
+
+// syncOnExit syncs w before logrus exits. The exit handler is registered
+// once for all the writers.
+func syncOnExit(w *asyncWriter) {
+	exitOnce.Do(func() {
+		logrus.RegisterExitHandler(syncExitWriters)
+	})
+	exitWritersMu.Lock()
+	exitWriters = append(exitWriters, w)
+	exitWritersMu.Unlock()
+}
+
+func syncExitWriters() {
+	exitWritersMu.Lock()
+	defer exitWritersMu.Unlock()
+	for _, w := range exitWriters {
+		w.Sync()
+	}
+}
+
+// asyncWriter writes to the output from a goroutine using a bounded queue.
+// If drop is set, lines are discarded when the queue is full.
+type asyncWriter struct {
+	out   io.Writer
+	drop  bool
+	queue chan asyncItem
+}
+
+type asyncItem struct {
+	line []byte
+	done chan struct{}
+}
+
+func newAsyncWriter(out io.Writer, size int, drop bool) *asyncWriter {
+	registerLogMetrics()
+	w := &asyncWriter{
+		out:   out,
+		drop:  drop,
+		queue: make(chan asyncItem, size),
+	}
+	go w.run()
+	return w
+}
+
+func (w *asyncWriter) run() {
+	for item := range w.queue {
+		if item.done != nil {
+			close(item.done)
+			continue
+		}
+		w.out.Write(item.line)
+	}
+}
+
+// Write implements io.Writer interface.
+func (w *asyncWriter) Write(p []byte) (int, error) {
+	// suppressed entries are written empty
+	if len(p) == 0 {
+		return 0, nil
+	}
+	// loggers reuse their buffers, so a copy is required
+	item := asyncItem{line: append([]byte(nil), p...)}
+	if !w.drop {
+		w.queue <- item
+		return len(p), nil
+	}
+	select {
+	case w.queue <- item:
+	default:
+		logDroppedLines.Inc()
+	}
+	return len(p), nil
+}
+
+// Sync waits until queued lines are written.
+func (w *asyncWriter) Sync() error {
+	return w.flush(asyncFlushTimeout)
+}
+
+func (w *asyncWriter) flush(timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	done := make(chan struct{})
+	select {
+	case w.queue <- asyncItem{done: done}:
+	case <-expired:
+		return errFlushTimeout
+	}
+	select {
+	case <-done:
+		return nil
+	case <-expired:
+		return errFlushTimeout
+	}
+}
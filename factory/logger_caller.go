@@ -0,0 +1,75 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strings"
+	"sync"
+)
+
+var (
+	mainModule     string
+	mainModuleOnce sync.Once
+)
+
+// modulePath returns the path of the main module of the binary.
+func modulePath() string {
+	mainModuleOnce.Do(func() {
+		if info, ok := debug.ReadBuildInfo(); ok {
+			mainModule = info.Main.Path
+		}
+	})
+	return mainModule
+}
+
+// callerPrettyfier returns the function and the file of a frame. File is
+// computed from the package of the function, so it doesn't depend on the
+// build environment, and is relative to the main module.
+func callerPrettyfier(f *runtime.Frame) (string, string) {
+	return formatCallerFunction(f.Function, ""), fmt.Sprintf("%s:%d", callerFile(f.Function, f.File), f.Line)
+}
+
+// formatCallerFunction returns the function reported by a format, ecs uses
+// the name without parentheses like the ecs formatter.
+func formatCallerFunction(function, format string) string {
+	if format == "ecs" {
+		return callerFunction(function)
+	}
+	return callerFunction(function) + "()"
+}
+
+func callerFunction(function string) string {
+	if slash := strings.LastIndex(function, "/"); slash >= 0 {
+		return function[slash+1:]
+	}
+	return function
+}
+
+func callerFile(function, file string) string {
+	pkg := framePackage(function)
+	switch pkg {
+	case "":
+		return file
+	case "main":
+		return filepath.Base(file)
+	}
+	path := pkg + "/" + filepath.Base(file)
+	if module := modulePath(); module != "" {
+		path = strings.TrimPrefix(path, module+"/")
+	}
+	return path
+}
+
+// framePackage returns the import path of the package from a function name.
+func framePackage(function string) string {
+	slash := strings.LastIndex(function, "/")
+	dot := strings.Index(function[slash+1:], ".")
+	if dot < 0 {
+		return ""
+	}
+	return function[:slash+1+dot]
+}
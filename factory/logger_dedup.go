@@ -0,0 +1,170 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// dedupFormatter suppresses messages repeated within the window defined for
+// its level. When the window expires, a summary with the number of suppressed
+// messages is written to out.
+type dedupFormatter struct {
+	logrus.Formatter
+	windows map[logrus.Level]time.Duration
+	out     io.Writer
+
+	mu     sync.Mutex
+	next   time.Time
+	timer  *time.Timer
+	logger *logrus.Logger
+	seen   map[dedupKey]*dedupItem
+}
+
+type dedupKey struct {
+	level   logrus.Level
+	message string
+}
+
+type dedupItem struct {
+	since    time.Time
+	repeated int
+}
+
+func newDedupFormatter(f logrus.Formatter, windows map[logrus.Level]time.Duration, out io.Writer) *dedupFormatter {
+	return &dedupFormatter{
+		Formatter: f,
+		windows:   windows,
+		out:       out,
+		seen:      make(map[dedupKey]*dedupItem),
+	}
+}
+
+// parseDupsWindow returns windows from a validated config map.
+func parseDupsWindow(m map[string]string) map[logrus.Level]time.Duration {
+	windows := make(map[logrus.Level]time.Duration, len(m))
+	for key, value := range m {
+		level, err := logrus.ParseLevel(strings.ToLower(key))
+		if err != nil {
+			continue
+		}
+		window, err := time.ParseDuration(value)
+		if err != nil {
+			continue
+		}
+		windows[level] = window
+	}
+	return windows
+}
+
+func (f *dedupFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	summary, err := f.expire(entry.Time, false)
+	if err != nil {
+		return nil, err
+	}
+	window, ok := f.windows[entry.Level]
+	if ok {
+		key := dedupKey{level: entry.Level, message: entry.Message}
+		if item, found := f.seen[key]; found {
+			item.repeated++
+			return summary, nil
+		}
+		f.logger = entry.Logger
+		f.seen[key] = &dedupItem{since: entry.Time}
+		if f.next.IsZero() || entry.Time.Add(window).Before(f.next) {
+			f.next = entry.Time.Add(window)
+		}
+		f.schedule()
+	}
+	serialized, err := f.Formatter.Format(entry)
+	if err != nil {
+		return nil, err
+	}
+	if len(summary) == 0 {
+		return serialized, nil
+	}
+	return append(summary, serialized...), nil
+}
+
+// schedule sets the timer that writes the summaries when the next window
+// expires, so counts are not lost if no more entries are logged.
+func (f *dedupFormatter) schedule() {
+	if f.next.IsZero() {
+		if f.timer != nil {
+			f.timer.Stop()
+		}
+		return
+	}
+	wait := time.Until(f.next)
+	if f.timer == nil {
+		f.timer = time.AfterFunc(wait, f.expired)
+		return
+	}
+	f.timer.Reset(wait)
+}
+
+func (f *dedupFormatter) expired() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	summary, _ := f.expire(time.Now(), false)
+	f.schedule()
+	if len(summary) > 0 {
+		f.out.Write(summary)
+	}
+}
+
+// flush writes the summaries of all the suppressed messages.
+func (f *dedupFormatter) flush() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	summary, err := f.expire(time.Now(), true)
+	f.schedule()
+	if err != nil {
+		return err
+	}
+	if len(summary) > 0 {
+		_, err = f.out.Write(summary)
+	}
+	return err
+}
+
+// expire removes expired items, or all of them if all is set, and returns
+// the summaries of the suppressed messages.
+func (f *dedupFormatter) expire(now time.Time, all bool) ([]byte, error) {
+	if f.next.IsZero() || (!all && now.Before(f.next)) {
+		return nil, nil
+	}
+	var summary []byte
+	f.next = time.Time{}
+	for key, item := range f.seen {
+		expires := item.since.Add(f.windows[key.level])
+		if !all && now.Before(expires) {
+			if f.next.IsZero() || expires.Before(f.next) {
+				f.next = expires
+			}
+			continue
+		}
+		delete(f.seen, key)
+		if item.repeated == 0 {
+			continue
+		}
+		repeated := logrus.NewEntry(f.logger)
+		repeated.Time = now
+		repeated.Level = key.level
+		repeated.Message = fmt.Sprintf("message repeated %d times: %s", item.repeated, key.message)
+		serialized, err := f.Formatter.Format(repeated)
+		if err != nil {
+			return nil, err
+		}
+		summary = append(summary, serialized...)
+	}
+	return summary, nil
+}
@@ -0,0 +1,186 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/luids-io/api/event"
+	"github.com/luids-io/common/config"
+	"github.com/luids-io/core/apiservice"
+	"github.com/luids-io/core/yalogi"
+)
+
+const (
+	// eventQueueSize is the number of events waiting to be notified.
+	eventQueueSize = 64
+	// eventTimeout is the default timeout notifying an event.
+	eventTimeout = 5 * time.Second
+)
+
+// LoggerEvents adds to a logger created by Logger a hook that notifies log
+// entries as events using the event notifier service defined in cfg.
+// It does nothing if no event service is configured.
+func LoggerEvents(cfg *config.LoggerCfg, registry apiservice.Discover, logger yalogi.Logger) error {
+	if cfg.EventService == "" {
+		return nil
+	}
+	err := cfg.Validate()
+	if err != nil {
+		return fmt.Errorf("invalid logger config: %v", err)
+	}
+	lg, ok := logrusLogger(logger)
+	if !ok {
+		return errors.New("logger doesn't support event notification")
+	}
+	svc, ok := registry.GetService(cfg.EventService)
+	if !ok {
+		return fmt.Errorf("can't find service '%s'", cfg.EventService)
+	}
+	notifier, ok := svc.(event.Notifier)
+	if !ok {
+		return fmt.Errorf("service '%s' is not a notifier", cfg.EventService)
+	}
+	level := logrus.ErrorLevel
+	if cfg.EventLevel != "" {
+		level, _ = logrus.ParseLevel(strings.ToLower(cfg.EventLevel))
+	}
+	timeout := cfg.EventTimeout
+	if timeout == 0 {
+		timeout = eventTimeout
+	}
+	lg.AddHook(newEventHook(notifier, level, event.Code(cfg.EventCode), cfg.EventRate, timeout))
+	return nil
+}
+
+type noEventsKey struct{}
+
+// LoggerWithoutEvents returns a logger whose entries are never notified as
+// events by LoggerEvents. Other loggers are returned unchanged.
+func LoggerWithoutEvents(logger yalogi.Logger) yalogi.Logger {
+	switch l := logger.(type) {
+	case *logrus.Logger:
+		return l.WithContext(withoutEvents(context.Background()))
+	case *logrus.Entry:
+		ctx := l.Context
+		if ctx == nil {
+			ctx = context.Background()
+		}
+		return l.WithContext(withoutEvents(ctx))
+	}
+	return logger
+}
+
+func withoutEvents(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noEventsKey{}, true)
+}
+
+// eventHook notifies entries as events from a goroutine. Entries logged
+// while a notification is in flight or by a logger returned by
+// LoggerWithoutEvents are ignored, so messages logged by the notifier itself
+// are never forwarded. Notifiers log through loggers without context, so
+// their entries can't be told apart from the others.
+type eventHook struct {
+	levels    []logrus.Level
+	notifier  event.Notifier
+	code      event.Code
+	timeout   time.Duration
+	queue     chan event.Event
+	notifying int32
+
+	mu    sync.Mutex
+	rate  int
+	sent  int
+	since time.Time
+}
+
+func newEventHook(notifier event.Notifier, level logrus.Level, code event.Code, rate int, timeout time.Duration) *eventHook {
+	h := &eventHook{
+		levels:   logrus.AllLevels[:level+1],
+		notifier: notifier,
+		code:     code,
+		timeout:  timeout,
+		queue:    make(chan event.Event, eventQueueSize),
+		rate:     rate,
+	}
+	go h.run()
+	return h
+}
+
+// Levels implements logrus.Hook interface.
+func (h *eventHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire implements logrus.Hook interface.
+func (h *eventHook) Fire(entry *logrus.Entry) error {
+	if atomic.LoadInt32(&h.notifying) != 0 {
+		return nil
+	}
+	if entry.Context != nil && entry.Context.Value(noEventsKey{}) != nil {
+		return nil
+	}
+	if !h.allow(entry.Time) {
+		return nil
+	}
+	e := event.New(h.code, eventLevel(entry.Level))
+	e.Data = make(map[string]interface{}, len(entry.Data)+2)
+	for k, v := range entry.Data {
+		e.Data[k] = fmt.Sprint(v)
+	}
+	e.Data["level"] = entry.Level.String()
+	e.Data["message"] = entry.Message
+	select {
+	case h.queue <- e:
+	default:
+	}
+	return nil
+}
+
+// allow applies the rate limit of events per minute.
+func (h *eventHook) allow(t time.Time) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if t.Sub(h.since) >= time.Minute {
+		h.since = t
+		h.sent = 0
+	}
+	if h.sent >= h.rate {
+		return false
+	}
+	h.sent++
+	return true
+}
+
+func (h *eventHook) run() {
+	for e := range h.queue {
+		ctx, cancel := context.WithTimeout(withoutEvents(context.Background()), h.timeout)
+		atomic.StoreInt32(&h.notifying, 1)
+		// errors can't be logged without generating new events
+		h.notifier.NotifyEvent(ctx, e)
+		atomic.StoreInt32(&h.notifying, 0)
+		cancel()
+	}
+}
+
+func eventLevel(level logrus.Level) event.Level {
+	switch level {
+	case logrus.PanicLevel, logrus.FatalLevel:
+		return event.Critical
+	case logrus.ErrorLevel:
+		return event.High
+	case logrus.WarnLevel:
+		return event.Medium
+	case logrus.InfoLevel:
+		return event.Low
+	}
+	return event.Info
+}
@@ -0,0 +1,54 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/luids-io/api/event"
+	"github.com/luids-io/common/config"
+	"github.com/luids-io/core/yalogi"
+)
+
+// loopNotifier fails and logs the error through the logger it notifies.
+type loopNotifier struct {
+	logger yalogi.Logger
+	calls  int32
+	done   chan struct{}
+}
+
+func (n *loopNotifier) NotifyEvent(ctx context.Context, e event.Event) (string, error) {
+	atomic.AddInt32(&n.calls, 1)
+	err := errors.New("notifier unavailable")
+	n.logger.Errorf("notifying event: %v", err)
+	n.done <- struct{}{}
+	return "", err
+}
+
+func TestLoggerEventsLoop(t *testing.T) {
+	logger, _ := testLogger(t, &config.LoggerCfg{Level: "info"})
+	lg, ok := logrusLogger(logger)
+	if !ok {
+		t.Fatal("logger isn't a logrus logger")
+	}
+	notifier := &loopNotifier{logger: logger, done: make(chan struct{}, eventQueueSize)}
+	lg.AddHook(newEventHook(notifier, logrus.ErrorLevel, 1, 1000, time.Second))
+
+	logger.Errorf("message")
+	select {
+	case <-notifier.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("event wasn't notified")
+	}
+	// a forwarded error would be notified right after
+	time.Sleep(100 * time.Millisecond)
+	if got := atomic.LoadInt32(&notifier.calls); got != 1 {
+		t.Errorf("calls = %v, want 1", got)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/luids-io/core/yalogi"
+)
+
+// LazyField is a field value that is computed only when an entry with the
+// field is written, so expensive values don't penalize disabled levels.
+type LazyField struct {
+	compute func() interface{}
+}
+
+// Lazy returns a field value computed by f.
+func Lazy(f func() interface{}) LazyField {
+	return LazyField{compute: f}
+}
+
+func (f LazyField) value() interface{} {
+	if f.compute == nil {
+		return nil
+	}
+	return f.compute()
+}
+
+// LoggerWithFields returns a logger that adds fields to all the entries of a
+// logger created by Logger. Values of type LazyField are evaluated only if
+// the level of the entry is enabled. Other loggers are returned unchanged.
+//
+// Loggers using the logrus backend implement logrus.Ext1FieldLogger, so
+// trace level and per entry fields are available with a type assertion.
+func LoggerWithFields(logger yalogi.Logger, fields map[string]interface{}) yalogi.Logger {
+	switch l := logger.(type) {
+	case *logrus.Logger:
+		return l.WithFields(logrus.Fields(fields))
+	case *logrus.Entry:
+		return l.WithFields(logrus.Fields(fields))
+	case *slogLogger:
+		nl := &slogLogger{logger: l.logger, outs: l.outs, lazy: copyLazy(l.lazy, len(fields))}
+		for key, value := range fields {
+			if f, ok := value.(LazyField); ok {
+				nl.lazy[key] = f
+				continue
+			}
+			nl.logger = nl.logger.With(key, value)
+		}
+		return nl
+	case *zapLogger:
+		lazy := make(map[string]LazyField)
+		args := make([]interface{}, 0, 2*len(fields))
+		for key, value := range fields {
+			if f, ok := value.(LazyField); ok {
+				lazy[key] = f
+				continue
+			}
+			args = append(args, key, value)
+		}
+		sugar := l.With(args...)
+		if len(lazy) > 0 {
+			sugar = sugar.WithOptions(zap.WrapCore(func(core zapcore.Core) zapcore.Core {
+				return zapLazyCore{Core: core, lazy: lazy}
+			}))
+		}
+		return &zapLogger{SugaredLogger: sugar, outs: l.outs}
+	}
+	return logger
+}
+
+func copyLazy(m map[string]LazyField, extra int) map[string]LazyField {
+	c := make(map[string]LazyField, len(m)+extra)
+	for k, v := range m {
+		c[k] = v
+	}
+	return c
+}
+
+// lazyHook replaces lazy fields with their values. Hooks are only fired for
+// enabled levels, so values are never computed for discarded entries.
+type lazyHook struct{}
+
+// Levels implements logrus.Hook interface.
+func (lazyHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook interface.
+func (lazyHook) Fire(entry *logrus.Entry) error {
+	for key, value := range entry.Data {
+		if f, ok := value.(LazyField); ok {
+			entry.Data[key] = f.value()
+		}
+	}
+	return nil
+}
+
+// logrusLogger returns the logrus logger of a logger created by Logger or
+// by LoggerWithFields.
+func logrusLogger(logger yalogi.Logger) (*logrus.Logger, bool) {
+	switch l := logger.(type) {
+	case *logrus.Logger:
+		return l, true
+	case *logrus.Entry:
+		return l.Logger, true
+	}
+	return nil, false
+}
+
+// zapLazyCore adds lazy fields to the entries enabled by the wrapped core.
+// Fields added to a zap core are encoded immediately, so lazy fields must be
+// kept apart until the entry is written.
+type zapLazyCore struct {
+	zapcore.Core
+	lazy map[string]LazyField
+}
+
+// With implements zapcore.Core interface.
+func (c zapLazyCore) With(fields []zapcore.Field) zapcore.Core {
+	return zapLazyCore{Core: c.Core.With(fields), lazy: c.lazy}
+}
+
+// Check implements zapcore.Core interface.
+func (c zapLazyCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write implements zapcore.Core interface.
+func (c zapLazyCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	for key, f := range c.lazy {
+		fields = append(fields, zap.Any(key, f.value()))
+	}
+	// the wrapped core selects the outputs enabled for the entry
+	if inner := c.Core.Check(ent, nil); inner != nil {
+		inner.Write(fields...)
+	}
+	return nil
+}
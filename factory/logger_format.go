@@ -0,0 +1,400 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/luids-io/common/config"
+)
+
+const (
+	ecsVersion         = "1.6.0"
+	ecsTimestampFormat = "2006-01-02T15:04:05.000Z07:00"
+	cefVendor          = "luids"
+)
+
+// logrusFormatter returns the formatter for the format using the options
+// defined in cfg, it returns nil if no format is defined.
+func logrusFormatter(cfg *config.LoggerCfg, format string) logrus.Formatter {
+	fieldMap := logrusFieldMap(cfg.FieldMap)
+	switch strings.ToLower(format) {
+	case "log":
+		return &logrus.TextFormatter{
+			DisableTimestamp: true,
+			FieldMap:         fieldMap,
+			CallerPrettyfier: callerPrettyfier,
+		}
+	case "text":
+		return &logrus.TextFormatter{
+			FullTimestamp:    cfg.TimeFormat != "",
+			TimestampFormat:  cfg.TimeFormat,
+			FieldMap:         fieldMap,
+			CallerPrettyfier: callerPrettyfier,
+		}
+	case "logfmt":
+		return &logrus.TextFormatter{
+			DisableColors:    true,
+			FullTimestamp:    true,
+			QuoteEmptyFields: true,
+			TimestampFormat:  cfg.TimeFormat,
+			FieldMap:         fieldMap,
+			CallerPrettyfier: callerPrettyfier,
+		}
+	case "json":
+		return &logrus.JSONFormatter{
+			TimestampFormat:  cfg.TimeFormat,
+			FieldMap:         fieldMap,
+			CallerPrettyfier: callerPrettyfier,
+		}
+	case "ecs":
+		return &ecsFormatter{timestampFormat: cfg.TimeFormat, fieldMap: cfg.FieldMap}
+	case "cef":
+		return &cefFormatter{cefLayout: newCEFLayout(cfg)}
+	}
+	return nil
+}
+
+func logrusFieldMap(m map[string]string) logrus.FieldMap {
+	fieldMap := logrus.FieldMap{}
+	for key, value := range m {
+		switch key {
+		case "time":
+			fieldMap[logrus.FieldKeyTime] = value
+		case "level":
+			fieldMap[logrus.FieldKeyLevel] = value
+		case "msg":
+			fieldMap[logrus.FieldKeyMsg] = value
+		case "func":
+			fieldMap[logrus.FieldKeyFunc] = value
+		case "file":
+			fieldMap[logrus.FieldKeyFile] = value
+		}
+	}
+	return fieldMap
+}
+
+func buildVersion() string {
+	info, ok := debug.ReadBuildInfo()
+	if !ok || info.Main.Version == "" || info.Main.Version == "(devel)" {
+		return "0"
+	}
+	return info.Main.Version
+}
+
+// nopFormatter discards entries.
+type nopFormatter struct{}
+
+func (nopFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	return nil, nil
+}
+
+// utcFormatter converts entry time to UTC before formatting.
+type utcFormatter struct {
+	logrus.Formatter
+}
+
+func (f utcFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	entry.Time = entry.Time.UTC()
+	return f.Formatter.Format(entry)
+}
+
+// staticFields returns the fields that must be added to every entry.
+func staticFields(cfg *config.LoggerCfg) logrus.Fields {
+	fields := make(logrus.Fields, len(cfg.Fields)+3)
+	if cfg.HostFields {
+		fields["program"] = filepath.Base(os.Args[0])
+		fields["pid"] = os.Getpid()
+		if hostname, err := os.Hostname(); err == nil {
+			fields["hostname"] = hostname
+		}
+	}
+	for key, value := range cfg.Fields {
+		fields[key] = value
+	}
+	return fields
+}
+
+// fieldsFormatter adds static fields to entries before formatting, fields
+// of the entry take precedence.
+type fieldsFormatter struct {
+	logrus.Formatter
+	fields logrus.Fields
+}
+
+func (f fieldsFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	for key, value := range f.fields {
+		if _, ok := entry.Data[key]; !ok {
+			entry.Data[key] = value
+		}
+	}
+	return f.Formatter.Format(entry)
+}
+
+// ecsFormatter formats entries as Elastic Common Schema json documents.
+type ecsFormatter struct {
+	timestampFormat string
+	fieldMap        map[string]string
+}
+
+func (f *ecsFormatter) key(name, def string) string {
+	if key, ok := f.fieldMap[name]; ok {
+		return key
+	}
+	return def
+}
+
+func (f *ecsFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	data := make(logrus.Fields, len(entry.Data)+8)
+	for k, v := range entry.Data {
+		if err, ok := v.(error); ok {
+			data[k] = err.Error()
+			continue
+		}
+		data[k] = v
+	}
+	timestampFormat := f.timestampFormat
+	if timestampFormat == "" {
+		timestampFormat = ecsTimestampFormat
+	}
+	data[f.key("time", "@timestamp")] = entry.Time.Format(timestampFormat)
+	data[f.key("level", "log.level")] = entry.Level.String()
+	data[f.key("msg", "message")] = entry.Message
+	data["ecs.version"] = ecsVersion
+	if entry.HasCaller() {
+		data[f.key("func", "log.origin.function")] = formatCallerFunction(entry.Caller.Function, "ecs")
+		data[f.key("file", "log.origin.file.name")] = callerFile(entry.Caller.Function, entry.Caller.File)
+		data["log.origin.file.line"] = entry.Caller.Line
+	}
+	b := entry.Buffer
+	if b == nil {
+		b = &bytes.Buffer{}
+	}
+	encoder := json.NewEncoder(b)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(data); err != nil {
+		return nil, fmt.Errorf("failed to marshal fields to JSON, %v", err)
+	}
+	return b.Bytes(), nil
+}
+
+// cefFormatter formats entries as ArcSight Common Event Format lines.
+type cefFormatter struct {
+	cefLayout
+}
+
+func (f *cefFormatter) Format(entry *logrus.Entry) ([]byte, error) {
+	b := entry.Buffer
+	if b == nil {
+		b = &bytes.Buffer{}
+	}
+	var function, file string
+	if entry.HasCaller() {
+		function, file = callerPrettyfier(entry.Caller)
+	}
+	f.write(b, entry.Time, entry.Level.String(), entry.Message, function, file, entry.Data)
+	return b.Bytes(), nil
+}
+
+// lineLayout writes entries of the formats that are implemented for all the
+// backends. Level is the name used by logrus and caller is formatted by
+// callerPrettyfier, function is empty if there is no caller.
+type lineLayout interface {
+	write(w *bytes.Buffer, t time.Time, level, msg, function, file string, data map[string]interface{})
+}
+
+func sortedKeys(data map[string]interface{}) []string {
+	keys := make([]string, 0, len(data))
+	for k := range data {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// logfmtLayout writes lines as the logfmt format of logrus.
+type logfmtLayout struct {
+	keys            logKeys
+	timestampFormat string
+	utc             bool
+}
+
+// logKeys are the names of the default fields.
+type logKeys struct {
+	time, level, msg, fn, file string
+}
+
+func (k *logKeys) override(m map[string]string) {
+	for key, value := range m {
+		switch key {
+		case "time":
+			k.time = value
+		case "level":
+			k.level = value
+		case "msg":
+			k.msg = value
+		case "func":
+			k.fn = value
+		case "file":
+			k.file = value
+		}
+	}
+}
+
+func newLogfmtLayout(cfg *config.LoggerCfg) logfmtLayout {
+	keys := logKeys{time: "time", level: "level", msg: "msg", fn: "func", file: "file"}
+	keys.override(cfg.FieldMap)
+	timestampFormat := cfg.TimeFormat
+	if timestampFormat == "" {
+		timestampFormat = time.RFC3339
+	}
+	return logfmtLayout{keys: keys, timestampFormat: timestampFormat, utc: cfg.UTC}
+}
+
+// write implements lineLayout interface.
+func (l logfmtLayout) write(w *bytes.Buffer, t time.Time, level, msg, function, file string, data map[string]interface{}) {
+	if l.utc {
+		t = t.UTC()
+	}
+	appendLogfmt(w, l.keys.time, t.Format(l.timestampFormat))
+	appendLogfmt(w, l.keys.level, level)
+	if msg != "" {
+		appendLogfmt(w, l.keys.msg, msg)
+	}
+	if function != "" {
+		appendLogfmt(w, l.keys.fn, function)
+		appendLogfmt(w, l.keys.file, file)
+	}
+	for _, k := range sortedKeys(data) {
+		appendLogfmt(w, k, fmt.Sprint(data[k]))
+	}
+	w.WriteByte('\n')
+}
+
+// appendLogfmt appends a key value pair quoted as the logrus text formatter.
+func appendLogfmt(w *bytes.Buffer, key, value string) {
+	if w.Len() > 0 {
+		w.WriteByte(' ')
+	}
+	w.WriteString(key)
+	w.WriteByte('=')
+	if logfmtNeedsQuoting(value) {
+		fmt.Fprintf(w, "%q", value)
+		return
+	}
+	w.WriteString(value)
+}
+
+func logfmtNeedsQuoting(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' ||
+			r == '-' || r == '.' || r == '_' || r == '/' || r == '@' || r == '^' || r == '+') {
+			return true
+		}
+	}
+	return false
+}
+
+// cefLayout writes Common Event Format lines for all the backends. Level
+// and message are header fields, so only time, func and file can be mapped.
+type cefLayout struct {
+	timestampFormat string
+	utc             bool
+	fieldMap        map[string]string
+	vendor          string
+	product         string
+	version         string
+}
+
+func newCEFLayout(cfg *config.LoggerCfg) cefLayout {
+	return cefLayout{
+		timestampFormat: cfg.TimeFormat,
+		utc:             cfg.UTC,
+		fieldMap:        cfg.FieldMap,
+		vendor:          cefVendor,
+		product:         filepath.Base(os.Args[0]),
+		version:         buildVersion(),
+	}
+}
+
+func (f cefLayout) key(name, def string) string {
+	if key := cefKey(f.fieldMap[name]); key != "" {
+		return key
+	}
+	return def
+}
+
+// write implements lineLayout interface.
+func (f cefLayout) write(w *bytes.Buffer, t time.Time, level, msg, function, file string, data map[string]interface{}) {
+	fmt.Fprintf(w, "CEF:0|%s|%s|%s|%s|%s|%d|",
+		cefHeader(f.vendor), cefHeader(f.product), cefHeader(f.version),
+		level, cefHeader(msg), cefSeverity(level))
+	if f.utc {
+		t = t.UTC()
+	}
+	if f.timestampFormat != "" {
+		fmt.Fprintf(w, "%s=%s", f.key("time", "rt"), cefValue(t.Format(f.timestampFormat)))
+	} else {
+		fmt.Fprintf(w, "%s=%d", f.key("time", "rt"), t.UnixNano()/1e6)
+	}
+	if function != "" {
+		fmt.Fprintf(w, " %s=%s %s=%s", f.key("func", "func"), cefValue(function),
+			f.key("file", "file"), cefValue(file))
+	}
+	for _, k := range sortedKeys(data) {
+		key := cefKey(k)
+		if key == "" {
+			continue
+		}
+		fmt.Fprintf(w, " %s=%s", key, cefValue(fmt.Sprint(data[k])))
+	}
+	w.WriteByte('\n')
+}
+
+func cefSeverity(level string) int {
+	switch level {
+	case "panic", "fatal":
+		return 10
+	case "error":
+		return 7
+	case "warning":
+		return 5
+	case "info":
+		return 3
+	}
+	return 1
+}
+
+var cefHeaderReplacer = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+
+func cefHeader(s string) string {
+	return cefHeaderReplacer.Replace(s)
+}
+
+var cefValueReplacer = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+func cefValue(s string) string {
+	return cefValueReplacer.Replace(s)
+}
+
+func cefKey(s string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '.' {
+			return r
+		}
+		return -1
+	}, s)
+}
@@ -0,0 +1,247 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/luids-io/core/yalogi"
+)
+
+// recentTailBuffer is the number of records buffered for each tail client.
+const recentTailBuffer = 64
+
+// logRecord is the representation of an entry kept in memory.
+type logRecord struct {
+	Time    time.Time         `json:"time"`
+	Level   string            `json:"level"`
+	Message string            `json:"msg"`
+	Fields  map[string]string `json:"fields,omitempty"`
+
+	level logrus.Level
+}
+
+// recentHook keeps the last entries by level in ring buffers and sends
+// entries to the tail subscribers. Buffers are not modified after creation.
+type recentHook struct {
+	buffers map[logrus.Level]*logRing
+	nsubs   int32
+
+	mu   sync.Mutex
+	subs map[chan logRecord]struct{}
+}
+
+type logRing struct {
+	records []logRecord
+	next    int
+	full    bool
+}
+
+func newRecentHook(sizes map[logrus.Level]int) *recentHook {
+	h := &recentHook{
+		buffers: make(map[logrus.Level]*logRing, len(sizes)),
+		subs:    make(map[chan logRecord]struct{}),
+	}
+	for level, size := range sizes {
+		h.buffers[level] = &logRing{records: make([]logRecord, size)}
+	}
+	return h
+}
+
+// parseRecent returns ring sizes from a validated config map.
+func parseRecent(m map[string]string) map[logrus.Level]int {
+	sizes := make(map[logrus.Level]int, len(m))
+	for key, value := range m {
+		level, err := logrus.ParseLevel(strings.ToLower(key))
+		if err != nil {
+			continue
+		}
+		size, err := strconv.Atoi(value)
+		if err != nil || size <= 0 {
+			continue
+		}
+		sizes[level] = size
+	}
+	return sizes
+}
+
+// Levels implements logrus.Hook interface.
+func (h *recentHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+// Fire implements logrus.Hook interface.
+func (h *recentHook) Fire(entry *logrus.Entry) error {
+	ring, kept := h.buffers[entry.Level]
+	if !kept && atomic.LoadInt32(&h.nsubs) == 0 {
+		return nil
+	}
+	r := logRecord{
+		Time:    entry.Time,
+		Level:   entry.Level.String(),
+		Message: entry.Message,
+		level:   entry.Level,
+	}
+	if len(entry.Data) > 0 {
+		r.Fields = make(map[string]string, len(entry.Data))
+		for k, v := range entry.Data {
+			r.Fields[k] = fmt.Sprint(v)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if kept {
+		ring.records[ring.next] = r
+		ring.next = (ring.next + 1) % len(ring.records)
+		if ring.next == 0 {
+			ring.full = true
+		}
+	}
+	for sub := range h.subs {
+		select {
+		case sub <- r:
+		default:
+		}
+	}
+	return nil
+}
+
+// recent returns the records kept with level equal or higher than level
+// sorted by time.
+func (h *recentHook) recent(level logrus.Level) []logRecord {
+	h.mu.Lock()
+	records := make([]logRecord, 0)
+	for l, ring := range h.buffers {
+		if l > level {
+			continue
+		}
+		if ring.full {
+			records = append(records, ring.records[ring.next:]...)
+		}
+		records = append(records, ring.records[:ring.next]...)
+	}
+	h.mu.Unlock()
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Time.Before(records[j].Time)
+	})
+	return records
+}
+
+func (h *recentHook) subscribe() chan logRecord {
+	sub := make(chan logRecord, recentTailBuffer)
+	h.mu.Lock()
+	h.subs[sub] = struct{}{}
+	atomic.AddInt32(&h.nsubs, 1)
+	h.mu.Unlock()
+	return sub
+}
+
+func (h *recentHook) unsubscribe(sub chan logRecord) {
+	h.mu.Lock()
+	delete(h.subs, sub)
+	atomic.AddInt32(&h.nsubs, -1)
+	h.mu.Unlock()
+}
+
+// serveRecent returns the recent entries as a json array.
+func (h *recentHook) serveRecent(w http.ResponseWriter, r *http.Request) {
+	level, ok := requestLevel(w, r)
+	if !ok {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.recent(level))
+}
+
+// serveTail sends new entries as server-sent events.
+func (h *recentHook) serveTail(w http.ResponseWriter, r *http.Request) {
+	level, ok := requestLevel(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	flusher.Flush()
+
+	sub := h.subscribe()
+	defer h.unsubscribe(sub)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case record := <-sub:
+			if record.level > level {
+				continue
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "event: log\ndata: %s\n\n", data)
+			flusher.Flush()
+		}
+	}
+}
+
+// requestLevel returns the level defined in the query, it writes an error
+// to the client if level is invalid.
+func requestLevel(w http.ResponseWriter, r *http.Request) (logrus.Level, bool) {
+	value := r.URL.Query().Get("level")
+	if value == "" {
+		return logrus.TraceLevel, true
+	}
+	level, err := logrus.ParseLevel(strings.ToLower(value))
+	if err != nil {
+		http.Error(w, "invalid level", http.StatusBadRequest)
+		return level, false
+	}
+	return level, true
+}
+
+// LoggerHandler returns an http handler with the recent entries of a logger
+// created by Logger. Recent entries are served as a json array on /logs and
+// new entries as server-sent events on /logs/tail, both accept an optional
+// level query parameter.
+func LoggerHandler(logger yalogi.Logger) (http.Handler, error) {
+	recent, ok := loggerRecent(logger)
+	if !ok {
+		return nil, errors.New("logger doesn't keep recent entries")
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/logs", recent.serveRecent)
+	mux.HandleFunc("/logs/tail", recent.serveTail)
+	return mux, nil
+}
+
+// loggerRecent returns the recent entries hook of a logger created by Logger.
+func loggerRecent(logger yalogi.Logger) (*recentHook, bool) {
+	lg, ok := logrusLogger(logger)
+	if !ok {
+		return nil, false
+	}
+	for _, hooks := range lg.Hooks {
+		for _, hook := range hooks {
+			if recent, ok := hook.(*recentHook); ok {
+				return recent, true
+			}
+		}
+	}
+	return nil, false
+}
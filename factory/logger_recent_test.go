@@ -0,0 +1,70 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/luids-io/common/config"
+)
+
+func TestLoggerHandler(t *testing.T) {
+	logger, _ := testLogger(t, &config.LoggerCfg{Level: "info"})
+	if _, err := LoggerHandler(logger); err == nil {
+		t.Error("LoggerHandler without recent: expected error")
+	}
+
+	cfg := &config.LoggerCfg{Level: "info", Recent: map[string]string{"error": "2", "info": "1"}}
+	logger, _ = testLogger(t, cfg)
+	handler, err := LoggerHandler(logger)
+	if err != nil {
+		t.Fatalf("LoggerHandler: %v", err)
+	}
+	logger.Infof("info 1")
+	logger.Errorf("error 1")
+	logger.Infof("info 2")
+	logger.Errorf("error 2")
+	logger.Errorf("error 3")
+
+	var tests = []struct {
+		query string
+		code  int
+		want  []string
+	}{
+		{"", http.StatusOK, []string{"info 2", "error 2", "error 3"}},
+		{"?level=error", http.StatusOK, []string{"error 2", "error 3"}},
+		{"?level=bad", http.StatusBadRequest, nil},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest("GET", "/logs"+test.query, nil))
+		if w.Code != test.code {
+			t.Errorf("/logs%s: code = %v, want %v", test.query, w.Code, test.code)
+			continue
+		}
+		if test.code != http.StatusOK {
+			continue
+		}
+		var records []logRecord
+		if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+			t.Fatalf("/logs%s: unmarshal: %v", test.query, err)
+		}
+		got := make([]string, 0, len(records))
+		for _, r := range records {
+			got = append(got, r.Message)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("/logs%s: messages = %v, want %v", test.query, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("/logs%s: messages = %v, want %v", test.query, got, test.want)
+				break
+			}
+		}
+	}
+}
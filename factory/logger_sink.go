@@ -0,0 +1,106 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/luids-io/common/config"
+)
+
+// logSink is an output of a logger with its own level and format.
+type logSink struct {
+	out    io.Writer
+	level  string
+	format string
+}
+
+// logSinks opens the outputs defined in a validated configuration.
+func logSinks(cfg *config.LoggerCfg) ([]logSink, error) {
+	defs, err := cfg.SinksCfg()
+	if err != nil {
+		return nil, err
+	}
+	sinks := make([]logSink, 0, len(defs))
+	for _, def := range defs {
+		var out io.Writer
+		switch def.Output {
+		case "stderr":
+			out = os.Stderr
+		case "stdout":
+			out = os.Stdout
+		default:
+			file, err := os.OpenFile(def.Output, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0640)
+			if err != nil {
+				return nil, err
+			}
+			out = file
+		}
+		if cfg.AsyncBuffer > 0 {
+			out = newAsyncWriter(out, cfg.AsyncBuffer, strings.ToLower(cfg.AsyncPolicy) == "drop")
+		}
+		sinks = append(sinks, logSink{out: out, level: def.Level, format: def.Format})
+	}
+	return sinks, nil
+}
+
+// sinkHook writes entries to an output using its own formatter.
+type sinkHook struct {
+	levels    []logrus.Level
+	formatter logrus.Formatter
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// Levels implements logrus.Hook interface.
+func (h *sinkHook) Levels() []logrus.Level {
+	return h.levels
+}
+
+// Fire implements logrus.Hook interface.
+func (h *sinkHook) Fire(entry *logrus.Entry) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	serialized, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	_, err = h.out.Write(serialized)
+	return err
+}
+
+// logrusOutputs returns the outputs used by a logrus logger.
+func logrusOutputs(logger *logrus.Logger) []io.Writer {
+	outs := []io.Writer{logger.Out}
+	seen := make(map[*sinkHook]bool)
+	for _, hooks := range logger.Hooks {
+		for _, hook := range hooks {
+			if sink, ok := hook.(*sinkHook); ok && !seen[sink] {
+				seen[sink] = true
+				outs = append(outs, sink.out)
+			}
+		}
+	}
+	return outs
+}
+
+// logrusFormatters returns the formatters used by a logrus logger.
+func logrusFormatters(logger *logrus.Logger) []logrus.Formatter {
+	formatters := []logrus.Formatter{logger.Formatter}
+	seen := make(map[*sinkHook]bool)
+	for _, hooks := range logger.Hooks {
+		for _, hook := range hooks {
+			if sink, ok := hook.(*sinkHook); ok && !seen[sink] {
+				seen[sink] = true
+				formatters = append(formatters, sink.formatter)
+			}
+		}
+	}
+	return formatters
+}
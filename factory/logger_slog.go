@@ -0,0 +1,356 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/luids-io/common/config"
+)
+
+// Levels not defined by slog.
+const (
+	slogLevelTrace = slog.LevelDebug - 4
+	slogLevelFatal = slog.LevelError + 4
+	slogLevelPanic = slog.LevelError + 8
+)
+
+// slogLogger adapts a slog.Logger to the yalogi.Logger interface.
+type slogLogger struct {
+	logger *slog.Logger
+	outs   []io.Writer
+	lazy   map[string]LazyField
+}
+
+func newSlog(cfg *config.LoggerCfg, debug bool, sinks []logSink) *slogLogger {
+	handlers := make(slogTee, 0, len(sinks))
+	outs := make([]io.Writer, 0, len(sinks))
+	for _, sink := range sinks {
+		handlers = append(handlers, newSlogHandler(cfg, debug, sink))
+		outs = append(outs, sink.out)
+	}
+	var handler slog.Handler = handlers
+	if len(handlers) == 1 {
+		handler = handlers[0]
+	}
+	logger := slog.New(handler)
+	for key, value := range staticFields(cfg) {
+		logger = logger.With(key, value)
+	}
+	return &slogLogger{logger: logger, outs: outs}
+}
+
+func newSlogHandler(cfg *config.LoggerCfg, debug bool, sink logSink) slog.Handler {
+	level := slogLevel(sink.level)
+	if debug && level > slog.LevelDebug {
+		level = slog.LevelDebug
+	}
+	format := strings.ToLower(sink.format)
+	keys := logKeys{
+		time:  "time",
+		level: "level",
+		msg:   "msg",
+		fn:    "func",
+		file:  "file",
+	}
+	if format == "ecs" {
+		keys = logKeys{
+			time:  "@timestamp",
+			level: "log.level",
+			msg:   "message",
+			fn:    "log.origin.function",
+			file:  "log.origin.file.name",
+		}
+	}
+	keys.override(cfg.FieldMap)
+	timestampFormat := cfg.TimeFormat
+	if timestampFormat == "" {
+		timestampFormat = time.RFC3339
+		if format == "ecs" {
+			timestampFormat = ecsTimestampFormat
+		}
+	}
+	opts := &slog.HandlerOptions{
+		AddSource: cfg.Caller,
+		Level:     level,
+		ReplaceAttr: slogReplacer{
+			keys:            keys,
+			format:          format,
+			timestampFormat: timestampFormat,
+			utc:             cfg.UTC,
+			noTime:          format == "log",
+		}.replace,
+	}
+	switch format {
+	case "cef":
+		return newSlogLayoutHandler(sink.out, newCEFLayout(cfg), level, cfg.Caller)
+	case "logfmt":
+		return newSlogLayoutHandler(sink.out, newLogfmtLayout(cfg), level, cfg.Caller)
+	case "json":
+		return slog.NewJSONHandler(sink.out, opts)
+	case "ecs":
+		return slog.NewJSONHandler(sink.out, opts).WithAttrs([]slog.Attr{slog.String("ecs.version", ecsVersion)})
+	}
+	return slog.NewTextHandler(sink.out, opts)
+}
+
+func slogLevel(s string) slog.Level {
+	switch strings.ToLower(s) {
+	case "trace":
+		return slogLevelTrace
+	case "debug":
+		return slog.LevelDebug
+	case "warn", "warning":
+		return slog.LevelWarn
+	case "error":
+		return slog.LevelError
+	case "fatal":
+		return slogLevelFatal
+	case "panic":
+		return slogLevelPanic
+	}
+	return slog.LevelInfo
+}
+
+// Debugf implements yalogi.Logger interface.
+func (l *slogLogger) Debugf(template string, args ...interface{}) {
+	l.log(slog.LevelDebug, template, args...)
+}
+
+// Infof implements yalogi.Logger interface.
+func (l *slogLogger) Infof(template string, args ...interface{}) {
+	l.log(slog.LevelInfo, template, args...)
+}
+
+// Warnf implements yalogi.Logger interface.
+func (l *slogLogger) Warnf(template string, args ...interface{}) {
+	l.log(slog.LevelWarn, template, args...)
+}
+
+// Errorf implements yalogi.Logger interface.
+func (l *slogLogger) Errorf(template string, args ...interface{}) {
+	l.log(slog.LevelError, template, args...)
+}
+
+// Fatalf implements yalogi.Logger interface.
+func (l *slogLogger) Fatalf(template string, args ...interface{}) {
+	l.log(slogLevelFatal, template, args...)
+	for _, out := range l.outs {
+		if w, ok := out.(*asyncWriter); ok {
+			w.Sync()
+		}
+	}
+	os.Exit(1)
+}
+
+func (l *slogLogger) log(level slog.Level, template string, args ...interface{}) {
+	ctx := context.Background()
+	if !l.logger.Enabled(ctx, level) {
+		return
+	}
+	// skip runtime.Callers, log and the exported method
+	var pcs [1]uintptr
+	runtime.Callers(3, pcs[:])
+	r := slog.NewRecord(time.Now(), level, fmt.Sprintf(template, args...), pcs[0])
+	for key, f := range l.lazy {
+		r.AddAttrs(slog.Any(key, f.value()))
+	}
+	_ = l.logger.Handler().Handle(ctx, r)
+}
+
+// slogTee dispatches records to several handlers.
+type slogTee []slog.Handler
+
+// Enabled implements slog.Handler interface.
+func (t slogTee) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range t {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+// Handle implements slog.Handler interface.
+func (t slogTee) Handle(ctx context.Context, r slog.Record) error {
+	var err error
+	for _, h := range t {
+		if !h.Enabled(ctx, r.Level) {
+			continue
+		}
+		if herr := h.Handle(ctx, r.Clone()); herr != nil && err == nil {
+			err = herr
+		}
+	}
+	return err
+}
+
+// WithAttrs implements slog.Handler interface.
+func (t slogTee) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(slogTee, 0, len(t))
+	for _, h := range t {
+		handlers = append(handlers, h.WithAttrs(attrs))
+	}
+	return handlers
+}
+
+// WithGroup implements slog.Handler interface.
+func (t slogTee) WithGroup(name string) slog.Handler {
+	handlers := make(slogTee, 0, len(t))
+	for _, h := range t {
+		handlers = append(handlers, h.WithGroup(name))
+	}
+	return handlers
+}
+
+// slogLayoutHandler writes records with a layout shared with the other
+// backends. Attributes in groups are written with the group names as prefix.
+type slogLayoutHandler struct {
+	out    io.Writer
+	mu     *sync.Mutex
+	layout lineLayout
+	level  slog.Level
+	caller bool
+	attrs  map[string]interface{}
+	prefix string
+}
+
+func newSlogLayoutHandler(out io.Writer, layout lineLayout, level slog.Level, caller bool) *slogLayoutHandler {
+	return &slogLayoutHandler{out: out, mu: &sync.Mutex{}, layout: layout, level: level, caller: caller}
+}
+
+// Enabled implements slog.Handler interface.
+func (h *slogLayoutHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	return level >= h.level
+}
+
+// Handle implements slog.Handler interface.
+func (h *slogLayoutHandler) Handle(ctx context.Context, r slog.Record) error {
+	data := make(map[string]interface{}, len(h.attrs)+r.NumAttrs())
+	for k, v := range h.attrs {
+		data[k] = v
+	}
+	r.Attrs(func(a slog.Attr) bool {
+		addSlogAttr(data, h.prefix, a)
+		return true
+	})
+	var function, file string
+	if h.caller && r.PC != 0 {
+		frame, _ := runtime.CallersFrames([]uintptr{r.PC}).Next()
+		function, file = callerPrettyfier(&frame)
+	}
+	var b bytes.Buffer
+	h.layout.write(&b, r.Time, slogLevelName(r.Level), r.Message, function, file, data)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	_, err := h.out.Write(b.Bytes())
+	return err
+}
+
+// WithAttrs implements slog.Handler interface.
+func (h *slogLayoutHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	nh := *h
+	nh.attrs = make(map[string]interface{}, len(h.attrs)+len(attrs))
+	for k, v := range h.attrs {
+		nh.attrs[k] = v
+	}
+	for _, a := range attrs {
+		addSlogAttr(nh.attrs, h.prefix, a)
+	}
+	return &nh
+}
+
+// WithGroup implements slog.Handler interface.
+func (h *slogLayoutHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	nh := *h
+	nh.prefix = h.prefix + name + "."
+	return &nh
+}
+
+func addSlogAttr(data map[string]interface{}, prefix string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Value.Kind() == slog.KindGroup {
+		if a.Key != "" {
+			prefix = prefix + a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			addSlogAttr(data, prefix, ga)
+		}
+		return
+	}
+	if a.Key == "" {
+		return
+	}
+	data[prefix+a.Key] = a.Value.Any()
+}
+
+// slogReplacer renames and formats built-in attributes so output matches
+// the logrus backend.
+type slogReplacer struct {
+	keys            logKeys
+	format          string
+	timestampFormat string
+	utc             bool
+	noTime          bool
+}
+
+func (r slogReplacer) replace(groups []string, a slog.Attr) slog.Attr {
+	if len(groups) > 0 {
+		return a
+	}
+	switch a.Key {
+	case slog.TimeKey:
+		if r.noTime {
+			return slog.Attr{}
+		}
+		t := a.Value.Time()
+		if r.utc {
+			t = t.UTC()
+		}
+		return slog.String(r.keys.time, t.Format(r.timestampFormat))
+	case slog.LevelKey:
+		level, _ := a.Value.Any().(slog.Level)
+		return slog.String(r.keys.level, slogLevelName(level))
+	case slog.MessageKey:
+		return slog.Attr{Key: r.keys.msg, Value: a.Value}
+	case slog.SourceKey:
+		src, ok := a.Value.Any().(*slog.Source)
+		if !ok {
+			return a
+		}
+		return slog.Group("",
+			slog.String(r.keys.fn, formatCallerFunction(src.Function, r.format)),
+			slog.String(r.keys.file, fmt.Sprintf("%s:%d", callerFile(src.Function, src.File), src.Line)))
+	}
+	return a
+}
+
+func slogLevelName(level slog.Level) string {
+	switch {
+	case level >= slogLevelPanic:
+		return "panic"
+	case level >= slogLevelFatal:
+		return "fatal"
+	case level >= slog.LevelError:
+		return "error"
+	case level >= slog.LevelWarn:
+		return "warning"
+	case level >= slog.LevelInfo:
+		return "info"
+	case level >= slog.LevelDebug:
+		return "debug"
+	}
+	return "trace"
+}
@@ -0,0 +1,148 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	dto "github.com/prometheus/client_model/go"
+
+	"github.com/luids-io/common/config"
+	"github.com/luids-io/core/yalogi"
+)
+
+func testLogger(t testing.TB, cfg *config.LoggerCfg) (yalogi.Logger, string) {
+	output := filepath.Join(t.TempDir(), "test.log")
+	cfg.Sinks = []string{"output=" + output}
+	logger, err := Logger(cfg, false)
+	if err != nil {
+		t.Fatalf("Logger(%+v): %v", cfg, err)
+	}
+	return logger, output
+}
+
+func TestLoggerCallerFunction(t *testing.T) {
+	var tests = []struct {
+		format string
+		key    string
+		want   string
+	}{
+		{"json", "func", "factory.TestLoggerCallerFunction()"},
+		{"ecs", "log.origin.function", "factory.TestLoggerCallerFunction"},
+	}
+	for _, backend := range []string{"logrus", "slog", "zap"} {
+		for _, test := range tests {
+			cfg := &config.LoggerCfg{Backend: backend, Level: "info", Format: test.format, Caller: true}
+			logger, output := testLogger(t, cfg)
+			logger.Infof("message")
+			data, err := ioutil.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var entry map[string]interface{}
+			if err := json.Unmarshal(data, &entry); err != nil {
+				t.Fatalf("%s %s: unmarshal '%s': %v", backend, test.format, data, err)
+			}
+			if got := entry[test.key]; got != test.want {
+				t.Errorf("%s %s: %s = %v, want %v", backend, test.format, test.key, got, test.want)
+			}
+		}
+	}
+}
+
+var timeRegexp = regexp.MustCompile(`(rt=[0-9]+|time="[^"]*")`)
+
+func TestLoggerFormatBackends(t *testing.T) {
+	for _, format := range []string{"cef", "logfmt"} {
+		var want string
+		for _, backend := range []string{"logrus", "slog", "zap"} {
+			cfg := &config.LoggerCfg{
+				Backend: backend,
+				Level:   "info",
+				Format:  format,
+				Caller:  true,
+				Fields:  map[string]string{"service": "test"},
+			}
+			logger, output := testLogger(t, cfg)
+			logger = LoggerWithFields(logger, map[string]interface{}{"key": "a value", "empty": ""})
+			logger.Warnf("message with spaces")
+			data, err := ioutil.ReadFile(output)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := timeRegexp.ReplaceAllString(string(data), "TIME")
+			if backend == "logrus" {
+				want = got
+				continue
+			}
+			if got != want {
+				t.Errorf("%s %s: got %q, want %q", backend, format, got, want)
+			}
+		}
+	}
+}
+
+func TestLoggerDedupAsync(t *testing.T) {
+	cfg := &config.LoggerCfg{
+		Level:       "info",
+		DupsWindow:  map[string]string{"error": "1m"},
+		AsyncBuffer: 4,
+		AsyncPolicy: "drop",
+	}
+	logger, output := testLogger(t, cfg)
+	dropped := droppedLines(t)
+	for i := 0; i < 200; i++ {
+		logger.Errorf("message")
+	}
+	if err := FlushLogger(logger, time.Second); err != nil {
+		t.Fatalf("FlushLogger: %v", err)
+	}
+	data, err := ioutil.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := bytes.Count(data, []byte("\n")); got != 2 {
+		t.Errorf("lines = %v, want 2 (message and summary)", got)
+	}
+	if got := droppedLines(t) - dropped; got != 0 {
+		t.Errorf("dropped lines = %v, want 0", got)
+	}
+}
+
+func droppedLines(t *testing.T) float64 {
+	var m dto.Metric
+	if err := logDroppedLines.Write(&m); err != nil {
+		t.Fatal(err)
+	}
+	return m.GetCounter().GetValue()
+}
+
+func benchmarkLogger(b *testing.B, backend string) {
+	cfg := &config.LoggerCfg{Backend: backend, Level: "info", Format: "json"}
+	logger, _ := testLogger(b, cfg)
+	logger = LoggerWithFields(logger, map[string]interface{}{"service": "bench"})
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Infof("message %d", i)
+		logger.Debugf("discarded %d", i)
+	}
+}
+
+func BenchmarkLoggerLogrus(b *testing.B) {
+	benchmarkLogger(b, "logrus")
+}
+
+func BenchmarkLoggerSlog(b *testing.B) {
+	benchmarkLogger(b, "slog")
+}
+
+func BenchmarkLoggerZap(b *testing.B) {
+	benchmarkLogger(b, "zap")
+}
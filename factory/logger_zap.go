@@ -0,0 +1,231 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/buffer"
+	"go.uber.org/zap/zapcore"
+
+	"github.com/luids-io/common/config"
+)
+
+// zapLogger wraps a zap.SugaredLogger keeping its outputs.
+type zapLogger struct {
+	*zap.SugaredLogger
+	outs []io.Writer
+}
+
+func newZap(cfg *config.LoggerCfg, debug bool, sinks []logSink) *zapLogger {
+	cores := make([]zapcore.Core, 0, len(sinks))
+	outs := make([]io.Writer, 0, len(sinks))
+	for _, sink := range sinks {
+		cores = append(cores, newZapCore(cfg, debug, sink))
+		outs = append(outs, sink.out)
+	}
+	opts := make([]zap.Option, 0, 2)
+	if cfg.Caller {
+		opts = append(opts, zap.AddCaller())
+	}
+	fields := make([]zap.Field, 0)
+	for key, value := range staticFields(cfg) {
+		fields = append(fields, zap.Any(key, value))
+	}
+	if len(fields) > 0 {
+		opts = append(opts, zap.Fields(fields...))
+	}
+	logger := zap.New(zapcore.NewTee(cores...), opts...)
+	return &zapLogger{SugaredLogger: logger.Sugar(), outs: outs}
+}
+
+func newZapCore(cfg *config.LoggerCfg, debug bool, sink logSink) zapcore.Core {
+	level := zapLevel(sink.level)
+	if debug && level > zapcore.DebugLevel {
+		level = zapcore.DebugLevel
+	}
+	format := strings.ToLower(sink.format)
+	encCfg := zapcore.EncoderConfig{
+		TimeKey:        "time",
+		LevelKey:       "level",
+		MessageKey:     "msg",
+		FunctionKey:    "func",
+		CallerKey:      "file",
+		LineEnding:     zapcore.DefaultLineEnding,
+		EncodeLevel:    zapLevelEncoder,
+		EncodeDuration: zapcore.StringDurationEncoder,
+		EncodeCaller:   zapCallerEncoder,
+	}
+	if format == "ecs" {
+		encCfg.TimeKey = "@timestamp"
+		encCfg.LevelKey = "log.level"
+		encCfg.MessageKey = "message"
+		encCfg.FunctionKey = "log.origin.function"
+		encCfg.CallerKey = "log.origin.file.name"
+	}
+	for key, value := range cfg.FieldMap {
+		switch key {
+		case "time":
+			encCfg.TimeKey = value
+		case "level":
+			encCfg.LevelKey = value
+		case "msg":
+			encCfg.MessageKey = value
+		case "func":
+			encCfg.FunctionKey = value
+		case "file":
+			encCfg.CallerKey = value
+		}
+	}
+	if format == "log" {
+		encCfg.TimeKey = zapcore.OmitKey
+	}
+	// zap encodes the full function name, so it's added by zapFuncCore
+	funcKey := encCfg.FunctionKey
+	encCfg.FunctionKey = zapcore.OmitKey
+	timestampFormat := cfg.TimeFormat
+	if timestampFormat == "" {
+		timestampFormat = time.RFC3339
+		if format == "ecs" {
+			timestampFormat = ecsTimestampFormat
+		}
+	}
+	encCfg.EncodeTime = zapTimeEncoder(timestampFormat, cfg.UTC)
+
+	var encoder zapcore.Encoder
+	switch format {
+	case "json", "ecs":
+		encoder = zapcore.NewJSONEncoder(encCfg)
+	case "cef":
+		encoder = newZapLayoutEncoder(newCEFLayout(cfg))
+		funcKey = zapcore.OmitKey
+	case "logfmt":
+		encoder = newZapLayoutEncoder(newLogfmtLayout(cfg))
+		funcKey = zapcore.OmitKey
+	default:
+		encoder = zapcore.NewConsoleEncoder(encCfg)
+	}
+	core := zapcore.NewCore(encoder, zapcore.Lock(zapcore.AddSync(sink.out)), level)
+	if format == "ecs" {
+		core = core.With([]zap.Field{zap.String("ecs.version", ecsVersion)})
+	}
+	return zapFuncCore{Core: core, key: funcKey, format: format}
+}
+
+// zapFuncCore adds the caller function with the same name as the other
+// backends.
+type zapFuncCore struct {
+	zapcore.Core
+	key    string
+	format string
+}
+
+// With implements zapcore.Core interface.
+func (c zapFuncCore) With(fields []zapcore.Field) zapcore.Core {
+	return zapFuncCore{Core: c.Core.With(fields), key: c.key, format: c.format}
+}
+
+// Check implements zapcore.Core interface.
+func (c zapFuncCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Core.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Write implements zapcore.Core interface.
+func (c zapFuncCore) Write(ent zapcore.Entry, fields []zapcore.Field) error {
+	if ent.Caller.Defined && ent.Caller.Function != "" && c.key != zapcore.OmitKey {
+		fields = append(fields, zap.String(c.key, formatCallerFunction(ent.Caller.Function, c.format)))
+	}
+	return c.Core.Write(ent, fields)
+}
+
+// zapLevel returns the zap level, trace is mapped to debug because zap
+// doesn't have a lower level.
+func zapLevel(s string) zapcore.Level {
+	switch strings.ToLower(s) {
+	case "trace", "debug":
+		return zapcore.DebugLevel
+	case "warn", "warning":
+		return zapcore.WarnLevel
+	case "error":
+		return zapcore.ErrorLevel
+	case "fatal":
+		return zapcore.FatalLevel
+	case "panic":
+		return zapcore.PanicLevel
+	}
+	return zapcore.InfoLevel
+}
+
+// zapLevelEncoder uses the same level names as logrus.
+func zapLevelEncoder(level zapcore.Level, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(zapLevelName(level))
+}
+
+func zapLevelName(level zapcore.Level) string {
+	if level == zapcore.WarnLevel {
+		return "warning"
+	}
+	return level.String()
+}
+
+var zapBufferPool = buffer.NewPool()
+
+// zapLayoutEncoder encodes entries with a layout shared with the other
+// backends.
+type zapLayoutEncoder struct {
+	*zapcore.MapObjectEncoder
+	layout lineLayout
+}
+
+func newZapLayoutEncoder(layout lineLayout) *zapLayoutEncoder {
+	return &zapLayoutEncoder{MapObjectEncoder: zapcore.NewMapObjectEncoder(), layout: layout}
+}
+
+// Clone implements zapcore.Encoder interface.
+func (e *zapLayoutEncoder) Clone() zapcore.Encoder {
+	c := newZapLayoutEncoder(e.layout)
+	for k, v := range e.Fields {
+		c.Fields[k] = v
+	}
+	return c
+}
+
+// EncodeEntry implements zapcore.Encoder interface.
+func (e *zapLayoutEncoder) EncodeEntry(ent zapcore.Entry, fields []zapcore.Field) (*buffer.Buffer, error) {
+	c := e.Clone().(*zapLayoutEncoder)
+	for _, f := range fields {
+		f.AddTo(c)
+	}
+	var function, file string
+	if ent.Caller.Defined {
+		function = formatCallerFunction(ent.Caller.Function, "")
+		file = fmt.Sprintf("%s:%d", callerFile(ent.Caller.Function, ent.Caller.File), ent.Caller.Line)
+	}
+	var b bytes.Buffer
+	e.layout.write(&b, ent.Time, zapLevelName(ent.Level), ent.Message, function, file, c.Fields)
+	buf := zapBufferPool.Get()
+	buf.Write(b.Bytes())
+	return buf, nil
+}
+
+// zapCallerEncoder uses the same file paths as the other backends.
+func zapCallerEncoder(caller zapcore.EntryCaller, enc zapcore.PrimitiveArrayEncoder) {
+	enc.AppendString(fmt.Sprintf("%s:%d", callerFile(caller.Function, caller.File), caller.Line))
+}
+
+func zapTimeEncoder(layout string, utc bool) zapcore.TimeEncoder {
+	return func(t time.Time, enc zapcore.PrimitiveArrayEncoder) {
+		if utc {
+			t = t.UTC()
+		}
+		enc.AppendString(t.Format(layout))
+	}
+}
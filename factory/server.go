@@ -6,36 +6,70 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"sort"
 	"sync"
+	"time"
 
 	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
 	grpc_prometheus "github.com/grpc-ecosystem/go-grpc-prometheus"
 	"google.golang.org/grpc"
+	channelzsvc "google.golang.org/grpc/channelz/service"
 	"google.golang.org/grpc/credentials"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 
 	"github.com/luids-io/common/config"
 	"github.com/luids-io/core/grpctls"
+	"github.com/luids-io/core/httphealth"
 	"github.com/luids-io/core/ipfilter"
+	"github.com/luids-io/core/yalogi"
 )
 
 // ErrURIServerExists defines error when a server for the uri was created.
 var ErrURIServerExists = errors.New("uri server already exists")
 
-// Server is a factory for a grpc server
-func Server(cfg *config.ServerCfg) (net.Listener, *grpc.Server, error) {
+// ErrURIServerConflict defines error when a server for the uri was created
+// with an incompatible configuration.
+var ErrURIServerConflict = errors.New("uri server exists with a different config")
+
+// Server is a factory for a grpc server. Servers are shared: if a server for
+// the uri was created with a compatible configuration, it returns the
+// listener and server with ErrURIServerExists and increments its references,
+// so only the first caller must serve it. Each user should call ReleaseServer
+// with the returned server when it doesn't need it anymore. Options are only
+// applied when the server is created.
+func Server(cfg *config.ServerCfg, opt ...ServerOption) (net.Listener, *grpc.Server, error) {
 	serverMutex.Lock()
 	defer serverMutex.Unlock()
 
 	// check in server pool
-	slis, srv, ok := serverPool.get(cfg.ListenURI)
-	if ok {
-		return slis, srv, ErrURIServerExists
+	if item, ok := serverPool.get(cfg.ListenURI); ok {
+		if !sameServerCfg(item.cfg, *cfg) {
+			return nil, nil, ErrURIServerConflict
+		}
+		item.refs++
+		return item.listener, item.server, ErrURIServerExists
 	}
 	// create server
 	err := cfg.Validate()
 	if err != nil {
 		return nil, nil, fmt.Errorf("invalid server config: %v", err)
 	}
+	opts := serverOpts{recovery: true}
+	for _, o := range opt {
+		o(&opts)
+	}
+	if cfg.AccessLog && opts.logger == nil {
+		return nil, nil, errors.New("access log requires a logger")
+	}
+	var auth *tokenAuth
+	if cfg.TokenFile != "" {
+		auth, err = newTokenAuth(cfg.TokenFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("loading tokens: %v", err)
+		}
+	}
 	var creds credentials.TransportCredentials
 	if cfg.TLS.UseTLS() {
 		creds, err = grpctls.Creds(cfg.TLS)
@@ -43,31 +77,131 @@ func Server(cfg *config.ServerCfg) (net.Listener, *grpc.Server, error) {
 			return nil, nil, fmt.Errorf("initializing TLS: %v", err)
 		}
 	}
-	slis, err = grpctls.Listener(cfg.ListenURI)
+	slis, err := grpctls.Listener(cfg.ListenURI)
 	if err != nil {
 		return nil, nil, fmt.Errorf("listening server: %v", err)
 	}
 
-	srv = grpc.NewServer(getGRPCServerOpts(
-		creds, ipfilter.Whitelist(cfg.Allowed), cfg.Metrics)...)
+	srv := grpc.NewServer(getGRPCServerOpts(cfg, creds, auth, opts)...)
 	//write in pool
-	serverPool.set(cfg.ListenURI, slis, srv)
-
+	item := serverPool.set(cfg.ListenURI, *cfg, slis, srv)
+	if cfg.Health {
+		item.health = newServerHealth(opts.health)
+		healthpb.RegisterHealthServer(srv, item.health.server)
+	}
+	if cfg.Reflection {
+		reflection.Register(srv)
+	}
+	if cfg.Channelz {
+		channelzsvc.RegisterChannelzServiceToServer(srv)
+	}
 	return slis, srv, nil
 }
 
+// ServerOption is used for server construction.
+type ServerOption func(*serverOpts)
+
+type serverOpts struct {
+	logger        yalogi.Logger
+	recovery      bool
+	health        map[string]httphealth.Pingable
+	uinterceptors []grpc.UnaryServerInterceptor
+	sinterceptors []grpc.StreamServerInterceptor
+	grpcopts      []grpc.ServerOption
+}
+
+// ServerLogger sets the logger used by the server interceptors.
+func ServerLogger(logger yalogi.Logger) ServerOption {
+	return func(o *serverOpts) {
+		o.logger = logger
+	}
+}
+
+// HealthCheck sets the check used for the status of a service in the grpc
+// health service. The status of the server (empty service name) is serving
+// only if all the checks are ok.
+func HealthCheck(service string, p httphealth.Pingable) ServerOption {
+	return func(o *serverOpts) {
+		if o.health == nil {
+			o.health = make(map[string]httphealth.Pingable)
+		}
+		o.health[service] = p
+	}
+}
+
+// Recovery enables the recovery of panics in handlers, enabled by default.
+// Panics are logged with the server logger, or to stderr if it isn't set, and
+// returned as codes.Internal.
+func Recovery(enable bool) ServerOption {
+	return func(o *serverOpts) {
+		o.recovery = enable
+	}
+}
+
+// UnaryInterceptors adds unary interceptors to the server. They are chained
+// in order after the built-in interceptors (access log, recovery, ipfilter,
+// token authentication, metrics and deadline).
+func UnaryInterceptors(i ...grpc.UnaryServerInterceptor) ServerOption {
+	return func(o *serverOpts) {
+		o.uinterceptors = append(o.uinterceptors, i...)
+	}
+}
+
+// StreamInterceptors adds stream interceptors to the server. They are chained
+// in order after the built-in interceptors (access log, recovery, ipfilter,
+// token authentication, metrics and deadline).
+func StreamInterceptors(i ...grpc.StreamServerInterceptor) ServerOption {
+	return func(o *serverOpts) {
+		o.sinterceptors = append(o.sinterceptors, i...)
+	}
+}
+
+// GRPCServerOptions adds options to the grpc server (e.g. stats handlers).
+// Interceptors must be added with UnaryInterceptors and StreamInterceptors.
+func GRPCServerOptions(opts ...grpc.ServerOption) ServerOption {
+	return func(o *serverOpts) {
+		o.grpcopts = append(o.grpcopts, opts...)
+	}
+}
+
 // setup grpc server middleware with server options
-func getGRPCServerOpts(creds credentials.TransportCredentials, ipfilter ipfilter.Filter, metrics bool) []grpc.ServerOption {
+func getGRPCServerOpts(cfg *config.ServerCfg, creds credentials.TransportCredentials, auth *tokenAuth, opts serverOpts) []grpc.ServerOption {
 	uinterceptors := make([]grpc.UnaryServerInterceptor, 0)
 	sinterceptors := make([]grpc.StreamServerInterceptor, 0)
-	if !ipfilter.Empty() {
-		uinterceptors = append(uinterceptors, ipfilter.UnaryServerInterceptor)
-		sinterceptors = append(sinterceptors, ipfilter.StreamServerInterceptor)
+	if cfg.AccessLog {
+		// first, so calls rejected by other interceptors are logged
+		access := newAccessLog(cfg, opts.logger)
+		uinterceptors = append(uinterceptors, access.unaryInterceptor)
+		sinterceptors = append(sinterceptors, access.streamInterceptor)
+	}
+	if opts.recovery {
+		recovery := newRecovery(opts.logger)
+		uinterceptors = append(uinterceptors, recovery.unaryInterceptor)
+		sinterceptors = append(sinterceptors, recovery.streamInterceptor)
+	}
+	filter := ipFilter{
+		allowed: ipfilter.Whitelist(cfg.Allowed),
+		debug:   ipfilter.Whitelist(cfg.DebugAllowed),
 	}
-	if metrics {
+	if !filter.empty() {
+		uinterceptors = append(uinterceptors, filter.unaryInterceptor)
+		sinterceptors = append(sinterceptors, filter.streamInterceptor)
+	}
+	if auth != nil {
+		uinterceptors = append(uinterceptors, auth.unaryInterceptor)
+		sinterceptors = append(sinterceptors, auth.streamInterceptor)
+	}
+	if cfg.Metrics {
 		uinterceptors = append(uinterceptors, grpc_prometheus.UnaryServerInterceptor)
 		sinterceptors = append(sinterceptors, grpc_prometheus.StreamServerInterceptor)
 	}
+	if deadlines := newMethodTimeouts(cfg.MaxDeadline, cfg.MaxDeadlines); !deadlines.empty() {
+		deadline := maxDeadline{timeouts: deadlines}
+		uinterceptors = append(uinterceptors, deadline.unaryInterceptor)
+		sinterceptors = append(sinterceptors, deadline.streamInterceptor)
+	}
+	uinterceptors = append(uinterceptors, opts.uinterceptors...)
+	sinterceptors = append(sinterceptors, opts.sinterceptors...)
 	//create options
 	grpcopts := make([]grpc.ServerOption, 0)
 	grpcopts = append(grpcopts,
@@ -78,33 +212,269 @@ func getGRPCServerOpts(creds credentials.TransportCredentials, ipfilter ipfilter
 	if creds != nil {
 		grpcopts = append(grpcopts, grpc.Creds(creds))
 	}
+	grpcopts = append(grpcopts, getGRPCConnOpts(cfg)...)
+	grpcopts = append(grpcopts, opts.grpcopts...)
+	return grpcopts
+}
+
+// sameServerCfg returns true if a server created with a can be shared by b.
+func sameServerCfg(a, b config.ServerCfg) bool {
+	if a.TLS != b.TLS || a.Metrics != b.Metrics || a.TokenFile != b.TokenFile {
+		return false
+	}
+	if a.AccessLog != b.AccessLog || a.AccessSample != b.AccessSample || a.Health != b.Health {
+		return false
+	}
+	if a.Reflection != b.Reflection || a.Channelz != b.Channelz || !sameSet(a.DebugAllowed, b.DebugAllowed) {
+		return false
+	}
+	if a.KeepaliveTime != b.KeepaliveTime || a.KeepaliveTimeout != b.KeepaliveTimeout ||
+		a.KeepaliveMinTime != b.KeepaliveMinTime || a.KeepalivePermit != b.KeepalivePermit {
+		return false
+	}
+	if a.MaxConnIdle != b.MaxConnIdle || a.MaxConnAge != b.MaxConnAge || a.MaxConnAgeGrace != b.MaxConnAgeGrace {
+		return false
+	}
+	if a.MaxDeadline != b.MaxDeadline || !sameMap(a.MaxDeadlines, b.MaxDeadlines) {
+		return false
+	}
+	if a.MaxRecvMsgSize != b.MaxRecvMsgSize || a.MaxSendMsgSize != b.MaxSendMsgSize ||
+		a.MaxConcurrentStreams != b.MaxConcurrentStreams || a.ConnTimeout != b.ConnTimeout {
+		return false
+	}
+	return sameSet(a.Allowed, b.Allowed) &&
+		sameSet(a.AccessInclude, b.AccessInclude) &&
+		sameSet(a.AccessExclude, b.AccessExclude)
+}
+
+// sameSet returns true if a and b have the same items in any order.
+func sameSet(a, b []string) bool {
+	set := make(map[string]bool, len(a))
+	for _, item := range a {
+		set[item] = true
+	}
+	found := make(map[string]bool, len(b))
+	for _, item := range b {
+		if !set[item] {
+			return false
+		}
+		found[item] = true
+	}
+	return len(found) == len(set)
+}
+
+// sameMap returns true if a and b have the same items.
+func sameMap(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if value, ok := b[k]; !ok || value != v {
+			return false
+		}
+	}
+	return true
+}
+
+// ServerURIs returns the listen uris of the servers in the pool.
+func ServerURIs() []string {
+	serverMutex.Lock()
+	defer serverMutex.Unlock()
+	return serverPool.uris()
+}
+
+// StopServer stops gracefully the server created for uri and removes it from
+// the pool regardless of its references. If the server doesn't stop before
+// timeout, it's stopped closing all connections. If timeout is zero it waits
+// indefinitely.
+func StopServer(uri string, timeout time.Duration) error {
+	serverMutex.Lock()
+	item, ok := serverPool.get(uri)
+	if !ok {
+		serverMutex.Unlock()
+		return fmt.Errorf("server '%s' doesn't exists", uri)
+	}
+	serverPool.remove(uri)
+	serverMutex.Unlock()
+
+	item.stop(timeout)
+	return nil
+}
+
+// StopServers stops all the servers in the pool and removes them. Servers
+// are stopped in parallel, timeout is applied to each server.
+func StopServers(timeout time.Duration) {
+	serverMutex.Lock()
+	items := serverPool.items
+	serverPool.items = make(map[string]*grpcItem)
+	serverMutex.Unlock()
+
+	var wg sync.WaitGroup
+	for _, item := range items {
+		wg.Add(1)
+		go func(item *grpcItem) {
+			defer wg.Done()
+			item.stop(timeout)
+		}(item)
+	}
+	wg.Wait()
+}
+
+// ReleaseServer decrements the references of the server created for uri.
+// srv must be the server returned by Server, so a release of a server that
+// was stopped doesn't affect a new server for the same uri. When there are no
+// more references, the server is stopped as in StopServer.
+func ReleaseServer(uri string, srv *grpc.Server, timeout time.Duration) error {
+	serverMutex.Lock()
+	item, ok := serverPool.get(uri)
+	if !ok {
+		serverMutex.Unlock()
+		return fmt.Errorf("server '%s' doesn't exists", uri)
+	}
+	if item.server != srv {
+		serverMutex.Unlock()
+		return fmt.Errorf("server '%s' was stopped", uri)
+	}
+	item.refs--
+	if item.refs > 0 {
+		serverMutex.Unlock()
+		return nil
+	}
+	serverPool.remove(uri)
+	serverMutex.Unlock()
+
+	item.stop(timeout)
+	return nil
+}
+
+// RemoveServer removes the server created for uri from the pool without
+// stopping it, so a new server can be created for the uri once the
+// caller has stopped it. Its health service is set as not serving. Returns
+// false if the uri isn't in the pool.
+func RemoveServer(uri string) bool {
+	serverMutex.Lock()
+	item, ok := serverPool.get(uri)
+	serverPool.remove(uri)
+	serverMutex.Unlock()
+
+	if ok && item.health != nil {
+		item.health.shutdown()
+	}
+	return ok
+}
+
+// ResetServers stops immediately all the servers in the pool and empties
+// it. It's intended for tests.
+func ResetServers() {
+	StopServers(time.Nanosecond)
+}
+
+// setup connection settings, zero values use grpc defaults
+func getGRPCConnOpts(cfg *config.ServerCfg) []grpc.ServerOption {
+	grpcopts := make([]grpc.ServerOption, 0)
+	kp := keepalive.ServerParameters{
+		MaxConnectionIdle:     cfg.MaxConnIdle,
+		MaxConnectionAge:      cfg.MaxConnAge,
+		MaxConnectionAgeGrace: cfg.MaxConnAgeGrace,
+		Time:                  cfg.KeepaliveTime,
+		Timeout:               cfg.KeepaliveTimeout,
+	}
+	if kp != (keepalive.ServerParameters{}) {
+		grpcopts = append(grpcopts, grpc.KeepaliveParams(kp))
+	}
+	if cfg.KeepaliveMinTime > 0 || cfg.KeepalivePermit {
+		grpcopts = append(grpcopts, grpc.KeepaliveEnforcementPolicy(keepalive.EnforcementPolicy{
+			MinTime:             cfg.KeepaliveMinTime,
+			PermitWithoutStream: cfg.KeepalivePermit,
+		}))
+	}
+	if cfg.MaxRecvMsgSize > 0 {
+		grpcopts = append(grpcopts, grpc.MaxRecvMsgSize(cfg.MaxRecvMsgSize))
+	}
+	if cfg.MaxSendMsgSize > 0 {
+		grpcopts = append(grpcopts, grpc.MaxSendMsgSize(cfg.MaxSendMsgSize))
+	}
+	if cfg.MaxConcurrentStreams > 0 {
+		grpcopts = append(grpcopts, grpc.MaxConcurrentStreams(cfg.MaxConcurrentStreams))
+	}
+	if cfg.ConnTimeout > 0 {
+		grpcopts = append(grpcopts, grpc.ConnectionTimeout(cfg.ConnTimeout))
+	}
 	return grpcopts
 }
 
 type grpcItem struct {
+	cfg      config.ServerCfg
 	listener net.Listener
 	server   *grpc.Server
+	health   *serverHealth
+	refs     int
+}
+
+// stop stops the server, the listener is closed too because grpc only closes
+// it if the server is serving.
+func (i *grpcItem) stop(timeout time.Duration) {
+	if i.health != nil {
+		i.health.shutdown()
+	}
+	done := make(chan struct{})
+	go func() {
+		i.server.GracefulStop()
+		close(done)
+	}()
+	if timeout > 0 {
+		select {
+		case <-done:
+		case <-time.After(timeout):
+			i.server.Stop()
+			<-done
+		}
+	} else {
+		<-done
+	}
+	i.listener.Close()
 }
 
 type grpcPool struct {
-	items map[string]grpcItem
+	items map[string]*grpcItem
 }
 
-func (p *grpcPool) get(uri string) (net.Listener, *grpc.Server, bool) {
+func (p *grpcPool) get(uri string) (*grpcItem, bool) {
 	item, ok := p.items[uri]
-	if !ok {
-		return nil, nil, false
+	return item, ok
+}
+
+func (p *grpcPool) set(uri string, cfg config.ServerCfg, lis net.Listener, srv *grpc.Server) *grpcItem {
+	cfg.Allowed = append([]string(nil), cfg.Allowed...)
+	cfg.AccessInclude = append([]string(nil), cfg.AccessInclude...)
+	cfg.AccessExclude = append([]string(nil), cfg.AccessExclude...)
+	cfg.DebugAllowed = append([]string(nil), cfg.DebugAllowed...)
+	maxDeadlines := make(map[string]string, len(cfg.MaxDeadlines))
+	for k, v := range cfg.MaxDeadlines {
+		maxDeadlines[k] = v
 	}
-	return item.listener, item.server, true
+	cfg.MaxDeadlines = maxDeadlines
+	item := &grpcItem{cfg: cfg, listener: lis, server: srv, refs: 1}
+	p.items[uri] = item
+	return item
 }
 
-func (p *grpcPool) set(uri string, lis net.Listener, srv *grpc.Server) {
-	p.items[uri] = grpcItem{listener: lis, server: srv}
+func (p *grpcPool) remove(uri string) {
+	delete(p.items, uri)
+}
+
+func (p *grpcPool) uris() []string {
+	uris := make([]string, 0, len(p.items))
+	for uri := range p.items {
+		uris = append(uris, uri)
+	}
+	sort.Strings(uris)
+	return uris
 }
 
 var serverMutex sync.Mutex
 var serverPool grpcPool
 
 func init() {
-	serverPool = grpcPool{items: make(map[string]grpcItem)}
+	serverPool = grpcPool{items: make(map[string]*grpcItem)}
 }
@@ -0,0 +1,144 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"path"
+	"sync/atomic"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/luids-io/common/config"
+	"github.com/luids-io/core/yalogi"
+)
+
+// accessLog logs the calls to a grpc server.
+type accessLog struct {
+	logger  yalogi.Logger
+	include []string
+	exclude []string
+	sample  uint64
+	calls   uint64
+}
+
+func newAccessLog(cfg *config.ServerCfg, logger yalogi.Logger) *accessLog {
+	return &accessLog{
+		logger:  logger,
+		include: cfg.AccessInclude,
+		exclude: cfg.AccessExclude,
+		sample:  uint64(cfg.AccessSample),
+	}
+}
+
+// match returns true if calls to method must be logged.
+func (a *accessLog) match(method string) bool {
+	for _, pattern := range a.exclude {
+		if ok, _ := path.Match(pattern, method); ok {
+			return false
+		}
+	}
+	if len(a.include) == 0 {
+		return true
+	}
+	for _, pattern := range a.include {
+		if ok, _ := path.Match(pattern, method); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// sampled applies sampling to successful calls, failed calls are always
+// logged.
+func (a *accessLog) sampled(err error) bool {
+	if err != nil || a.sample <= 1 {
+		return true
+	}
+	n := atomic.AddUint64(&a.calls, 1)
+	return (n-1)%a.sample == 0
+}
+
+func (a *accessLog) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	if !a.match(info.FullMethod) {
+		return handler(ctx, req)
+	}
+	start := time.Now()
+	resp, err := handler(ctx, req)
+	a.log(ctx, info.FullMethod, start, err, int64(messageSize(req)), int64(messageSize(resp)))
+	return resp, err
+}
+
+func (a *accessLog) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	if !a.match(info.FullMethod) {
+		return handler(srv, ss)
+	}
+	start := time.Now()
+	stream := &accessStream{ServerStream: ss}
+	err := handler(srv, stream)
+	a.log(ss.Context(), info.FullMethod, start, err, atomic.LoadInt64(&stream.recv), atomic.LoadInt64(&stream.sent))
+	return err
+}
+
+func (a *accessLog) log(ctx context.Context, method string, start time.Time, err error, recv, sent int64) {
+	if !a.sampled(err) {
+		return
+	}
+	addr, identity := peerInfo(ctx)
+	a.logger.Infof("access method=%s peer=%s identity=%s code=%s duration=%v recv=%d sent=%d",
+		method, addr, identity, status.Code(err), time.Since(start), recv, sent)
+}
+
+// accessStream counts the size of the messages of a stream.
+type accessStream struct {
+	grpc.ServerStream
+	recv, sent int64
+}
+
+// RecvMsg implements grpc.ServerStream interface.
+func (s *accessStream) RecvMsg(m interface{}) error {
+	err := s.ServerStream.RecvMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.recv, int64(messageSize(m)))
+	}
+	return err
+}
+
+// SendMsg implements grpc.ServerStream interface.
+func (s *accessStream) SendMsg(m interface{}) error {
+	err := s.ServerStream.SendMsg(m)
+	if err == nil {
+		atomic.AddInt64(&s.sent, int64(messageSize(m)))
+	}
+	return err
+}
+
+func messageSize(m interface{}) int {
+	if msg, ok := m.(proto.Message); ok {
+		return proto.Size(msg)
+	}
+	return 0
+}
+
+// peerInfo returns the address and the tls identity of the peer.
+func peerInfo(ctx context.Context) (string, string) {
+	addr, identity := "-", "-"
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return addr, identity
+	}
+	if p.Addr != nil {
+		addr = p.Addr.String()
+	}
+	if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.PeerCertificates) > 0 {
+		if cn := info.State.PeerCertificates[0].Subject.CommonName; cn != "" {
+			identity = cn
+		}
+	}
+	return addr, identity
+}
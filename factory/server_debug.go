@@ -0,0 +1,62 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"strings"
+
+	"google.golang.org/grpc"
+
+	"github.com/luids-io/core/ipfilter"
+)
+
+// debugServices are the prefixes of the methods of debug services.
+var debugServices = []string{
+	"/grpc.reflection.v1.ServerReflection/",
+	"/grpc.reflection.v1alpha.ServerReflection/",
+	"/grpc.channelz.v1.Channelz/",
+}
+
+func isDebugMethod(method string) bool {
+	for _, prefix := range debugServices {
+		if strings.HasPrefix(method, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ipFilter applies the allowed filter to the methods of the server. Debug
+// services are only checked against their own filter, if it's defined.
+type ipFilter struct {
+	allowed ipfilter.Filter
+	debug   ipfilter.Filter
+}
+
+func (f ipFilter) empty() bool {
+	return f.allowed.Empty() && f.debug.Empty()
+}
+
+func (f ipFilter) method(method string) ipfilter.Filter {
+	if !f.debug.Empty() && isDebugMethod(method) {
+		return f.debug
+	}
+	return f.allowed
+}
+
+func (f ipFilter) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	filter := f.method(info.FullMethod)
+	if filter.Empty() {
+		return handler(ctx, req)
+	}
+	return filter.UnaryServerInterceptor(ctx, req, info, handler)
+}
+
+func (f ipFilter) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	filter := f.method(info.FullMethod)
+	if filter.Empty() {
+		return handler(srv, ss)
+	}
+	return filter.StreamServerInterceptor(srv, ss, info, handler)
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"time"
+
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	"github.com/luids-io/core/httphealth"
+)
+
+// healthCheckInterval is the time between checks of the services.
+const healthCheckInterval = 5 * time.Second
+
+// serverHealth updates the status of a grpc health service from checks.
+type serverHealth struct {
+	server *health.Server
+	checks map[string]httphealth.Pingable
+	close  chan struct{}
+	done   chan struct{}
+}
+
+func newServerHealth(checks map[string]httphealth.Pingable) *serverHealth {
+	h := &serverHealth{
+		server: health.NewServer(),
+		checks: checks,
+		close:  make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	go h.run()
+	return h
+}
+
+// run checks the services until shutdown, the first check is done here so
+// a slow check doesn't block the server creation.
+func (h *serverHealth) run() {
+	defer close(h.done)
+	h.check()
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-h.close:
+			return
+		case <-ticker.C:
+			h.check()
+		}
+	}
+}
+
+func (h *serverHealth) check() {
+	overall := healthpb.HealthCheckResponse_SERVING
+	for service, p := range h.checks {
+		status := healthpb.HealthCheckResponse_SERVING
+		if err := p.Ping(); err != nil {
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+			overall = status
+		}
+		h.server.SetServingStatus(service, status)
+	}
+	h.server.SetServingStatus("", overall)
+}
+
+// shutdown sets all services as not serving, later checks are ignored.
+func (h *serverHealth) shutdown() {
+	h.server.Shutdown()
+	close(h.close)
+	<-h.done
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"context"
+	"log"
+	"runtime/debug"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/luids-io/core/yalogi"
+)
+
+var (
+	serverPanics = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "luids_grpc_server_panics_total",
+		Help: "Total number of panics recovered in grpc handlers.",
+	}, []string{"grpc_method"})
+	serverMetricsOnce sync.Once
+)
+
+func registerServerMetrics() {
+	serverMetricsOnce.Do(func() {
+		prometheus.MustRegister(serverPanics)
+	})
+}
+
+// recovery converts panics in handlers into codes.Internal errors. Panics
+// are logged to the standard logger if no logger is set, so their stack is
+// never lost.
+type recovery struct {
+	logger yalogi.Logger
+}
+
+func newRecovery(logger yalogi.Logger) *recovery {
+	registerServerMetrics()
+	return &recovery{logger: logger}
+}
+
+func (r *recovery) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = r.recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(ctx, req)
+}
+
+func (r *recovery) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = r.recovered(info.FullMethod, p)
+		}
+	}()
+	return handler(srv, ss)
+}
+
+func (r *recovery) recovered(method string, p interface{}) error {
+	serverPanics.WithLabelValues(method).Inc()
+	if r.logger == nil {
+		log.Printf("panic in %s: %v\n%s", method, p, debug.Stack())
+	} else {
+		r.logger.Errorf("panic in %s: %v\n%s", method, p, debug.Stack())
+	}
+	return status.Error(codes.Internal, "internal error")
+}
@@ -0,0 +1,39 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestRecoveryWithoutLogger(t *testing.T) {
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	r := newRecovery(nil)
+	info := &grpc.UnaryServerInfo{FullMethod: "/test.Service/Method"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		panic("boom")
+	}
+	_, err := r.unaryInterceptor(context.Background(), nil, info, handler)
+	if got := status.Code(err); got != codes.Internal {
+		t.Errorf("code = %v, want %v", got, codes.Internal)
+	}
+	output := buf.String()
+	if !strings.Contains(output, "panic in /test.Service/Method: boom") {
+		t.Errorf("panic not logged: %q", output)
+	}
+	if !strings.Contains(output, "runtime/debug.Stack") {
+		t.Errorf("stack not logged: %q", output)
+	}
+}
@@ -0,0 +1,232 @@
+// Copyright 2019 Luis Guillén Civera <luisguillenc@gmail.com>. View LICENSE.
+
+package factory
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	grpc_middleware "github.com/grpc-ecosystem/go-grpc-middleware"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	"github.com/luids-io/core/grpctls"
+)
+
+// tokenCheckInterval is the minimum time between checks of token files.
+const tokenCheckInterval = time.Second
+
+// tokenFile keeps the content of a file, reloading it when it changes.
+type tokenFile struct {
+	path  string
+	parse func([]byte) error
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	size    int64
+}
+
+func newTokenFile(path string, parse func([]byte) error) (*tokenFile, error) {
+	f := &tokenFile{path: path, parse: parse}
+	if err := f.reload(time.Now()); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// reload reads the file if it changed. On errors the last content is kept.
+func (f *tokenFile) reload(now time.Time) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if !f.checked.IsZero() && now.Sub(f.checked) < tokenCheckInterval {
+		return nil
+	}
+	f.checked = now
+	info, err := os.Stat(f.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(f.modTime) && info.Size() == f.size {
+		return nil
+	}
+	data, err := ioutil.ReadFile(f.path)
+	if err != nil {
+		return err
+	}
+	if err := f.parse(data); err != nil {
+		return fmt.Errorf("parsing token file '%s': %v", f.path, err)
+	}
+	f.modTime, f.size = info.ModTime(), info.Size()
+	return nil
+}
+
+// tokenCreds sends the token of a file as per-RPC credentials.
+type tokenCreds struct {
+	file    *tokenFile
+	secured bool
+
+	mu    sync.RWMutex
+	token string
+}
+
+// newTokenCreds returns the credentials for the uris of a connection. TLS is
+// required unless all of them are unix sockets.
+func newTokenCreds(path string, uris []string) (*tokenCreds, error) {
+	c := &tokenCreds{}
+	for _, uri := range uris {
+		if proto, _, _ := grpctls.ParseURI(uri); proto != "unix" {
+			c.secured = true
+		}
+	}
+	file, err := newTokenFile(path, c.parse)
+	if err != nil {
+		return nil, err
+	}
+	c.file = file
+	return c, nil
+}
+
+func (c *tokenCreds) parse(data []byte) error {
+	token := string(bytes.TrimSpace(data))
+	if token == "" {
+		return errors.New("empty token")
+	}
+	c.mu.Lock()
+	c.token = token
+	c.mu.Unlock()
+	return nil
+}
+
+// GetRequestMetadata implements credentials.PerRPCCredentials interface.
+func (c *tokenCreds) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	// a token that can't be reloaded is kept until the file is fixed
+	c.file.reload(time.Now())
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return map[string]string{"authorization": "Bearer " + c.token}, nil
+}
+
+// RequireTransportSecurity implements credentials.PerRPCCredentials interface.
+// Tokens are allowed without TLS only for local deployments over unix sockets.
+func (c *tokenCreds) RequireTransportSecurity() bool {
+	return c.secured
+}
+
+// tokenAuth validates the tokens of incoming calls against a file with one
+// "name:token" by line and puts the name in the context.
+type tokenAuth struct {
+	file *tokenFile
+
+	mu     sync.RWMutex
+	tokens map[string]string // token -> name
+}
+
+type tokenIdentityKey struct{}
+
+// TokenIdentity returns the name of the token used by the caller in a server
+// with token authentication.
+func TokenIdentity(ctx context.Context) (string, bool) {
+	name, ok := ctx.Value(tokenIdentityKey{}).(string)
+	return name, ok
+}
+
+func newTokenAuth(path string) (*tokenAuth, error) {
+	a := &tokenAuth{}
+	file, err := newTokenFile(path, a.parse)
+	if err != nil {
+		return nil, err
+	}
+	a.file = file
+	return a, nil
+}
+
+func (a *tokenAuth) parse(data []byte) error {
+	tokens := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for line := 1; scanner.Scan(); line++ {
+		s := strings.TrimSpace(scanner.Text())
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		kv := strings.SplitN(s, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" || strings.TrimSpace(kv[1]) == "" {
+			return fmt.Errorf("invalid line %d", line)
+		}
+		tokens[strings.TrimSpace(kv[1])] = strings.TrimSpace(kv[0])
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	a.mu.Lock()
+	a.tokens = tokens
+	a.mu.Unlock()
+	return nil
+}
+
+// authenticate returns the context with the identity of the caller.
+func (a *tokenAuth) authenticate(ctx context.Context, method string) (context.Context, error) {
+	// health probes can't send tokens
+	if strings.HasPrefix(method, "/grpc.health.v1.Health/") {
+		return ctx, nil
+	}
+	token := requestToken(ctx)
+	if token == "" {
+		return nil, status.Error(codes.Unauthenticated, "token required")
+	}
+	a.file.reload(time.Now())
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	for t, name := range a.tokens {
+		if subtle.ConstantTimeCompare([]byte(t), []byte(token)) == 1 {
+			return context.WithValue(ctx, tokenIdentityKey{}, name), nil
+		}
+	}
+	return nil, status.Error(codes.Unauthenticated, "invalid token")
+}
+
+// requestToken returns a bearer token or an api key from the metadata.
+func requestToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+	for _, value := range md.Get("authorization") {
+		if len(value) > 7 && strings.EqualFold(value[:7], "bearer ") {
+			return strings.TrimSpace(value[7:])
+		}
+	}
+	if values := md.Get("x-api-key"); len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
+func (a *tokenAuth) unaryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	ctx, err := a.authenticate(ctx, info.FullMethod)
+	if err != nil {
+		return nil, err
+	}
+	return handler(ctx, req)
+}
+
+func (a *tokenAuth) streamInterceptor(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx, err := a.authenticate(ss.Context(), info.FullMethod)
+	if err != nil {
+		return err
+	}
+	stream := grpc_middleware.WrapServerStream(ss)
+	stream.WrappedContext = ctx
+	return handler(srv, stream)
+}